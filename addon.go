@@ -2,6 +2,7 @@ package stremio
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -42,16 +43,53 @@ type ManifestCallback func(ctx context.Context, manifest *Manifest, userData int
 // The userData parameter depends on whether you called `RegisterUserData()` before:
 // If not, a simple string will be passed. It's empty if the user didn't provide user data.
 // If yes, a pointer to an object you registered will be passed. It's nil if the user didn't provide user data.
+// Return NotFound, BadRequest or an *Error to control the response's status code, any other error leads to "500 Internal Server Error".
+// Extra parameters of the request, like "skip" for pagination, can be read with `GetCatalogExtra(ctx)`.
 type CatalogHandler func(ctx context.Context, id string, userData interface{}) ([]MetaPreviewItem, error)
 
 // StreamHandler is the callback for stream requests for a specific type (like "movie").
-// The context parameter contains a meta object under the key "meta" if PutMetaInContext was set to true in the addon options.
+// The context parameter contains a meta object if PutMetaInContext was set to true in the addon options.
+// You can get it with `cinemeta.GetMetaFromContext()`.
 // The id parameter can be for example an IMDb ID if your addon handles the "movie" type.
 // The userData parameter depends on whether you called `RegisterUserData()` before:
 // If not, a simple string will be passed. It's empty if the user didn't provide user data.
 // If yes, a pointer to an object you registered will be passed. It's nil if the user didn't provide user data.
+// Return NotFound, BadRequest or an *Error to control the response's status code, any other error leads to "500 Internal Server Error".
 type StreamHandler func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error)
 
+// MetaHandler is the callback for meta requests for a specific type (like "movie").
+// Stremio sends them for the IDs of your catalog items, when the manifest contains the "meta" resource for their ID prefix.
+// The context parameter contains a meta object if PutMetaInContext was set to true in the addon options,
+// for example for addons that enrich Cinemeta's metadata. You can get it with `cinemeta.GetMetaFromContext()`.
+// The id parameter is the ID of the movie or TV show, without season and episode.
+// The userData parameter depends on whether you called `RegisterUserData()` before, like for the CatalogHandler.
+// Return NotFound, BadRequest or an *Error to control the response's status code, any other error leads to "500 Internal Server Error".
+type MetaHandler func(ctx context.Context, id string, userData interface{}) (MetaItem, error)
+
+// SubtitlesHandler is the callback for subtitles requests for a specific type (like "movie").
+// The context parameter contains a meta object if PutMetaInContext was set to true in the addon options.
+// You can get it with `cinemeta.GetMetaFromContext()`.
+// The id parameter has the same format as for the StreamHandler, e.g. "tt0944947:1:2" for a TV show episode.
+// Stremio sends extra parameters like "videoHash", "videoSize" and "filename", which can be read with `GetCatalogExtra(ctx).Values`.
+// The userData parameter depends on whether you called `RegisterUserData()` before, like for the StreamHandler.
+// Return NotFound, BadRequest or an *Error to control the response's status code, any other error leads to "500 Internal Server Error".
+type SubtitlesHandler func(ctx context.Context, id string, userData interface{}) ([]SubtitleItem, error)
+
+// UserDataValidator can be implemented by the user data type that you register with `RegisterUserData()`.
+// When it's implemented, go-stremio calls Validate after decoding the user data and responds with "400 Bad Request" if it returns an error,
+// so that your handlers only get called with valid user data.
+type UserDataValidator interface {
+	Validate() error
+}
+
+// UserDataMigration migrates user data from the version it was registered for to the next version.
+// It gets the user data JSON object as map and must return the migrated map.
+// Setting the new version in the map is not required, go-stremio takes care of that.
+type UserDataMigration func(userData map[string]interface{}) (map[string]interface{}, error)
+
+// userDataVersionKey is the key of the version field in the user data JSON object.
+const userDataVersionKey = "version"
+
 // MetaFetcher returns metadata for movies and TV shows.
 // It's used when you configure that the media name should be logged or that metadata should be put into the context.
 type MetaFetcher interface {
@@ -62,16 +100,23 @@ type MetaFetcher interface {
 // Addon represents a remote addon.
 // You can create one with NewAddon() and then run it with Run().
 type Addon struct {
-	manifest          Manifest
-	catalogHandlers   map[string]CatalogHandler
-	streamHandlers    map[string]StreamHandler
-	opts              Options
-	logger            *zap.Logger
-	customMiddlewares []customMiddleware
-	customEndpoints   []customEndpoint
-	manifestCallback  ManifestCallback
-	userDataType      reflect.Type
-	metaClient        MetaFetcher
+	manifest           Manifest
+	catalogHandlers    map[string]CatalogHandler
+	streamHandlers     map[string]StreamHandler
+	metaHandlers       map[string]MetaHandler
+	subtitlesHandlers  map[string]SubtitlesHandler
+	opts               Options
+	logger             *zap.Logger
+	customMiddlewares  []customMiddleware
+	customEndpoints    []customEndpoint
+	manifestCallback   ManifestCallback
+	userDataType       reflect.Type
+	userDataMigrations map[int]UserDataMigration
+	metaClient         MetaFetcher
+	beforeHooks        []BeforeHook
+	catalogAfterHooks  []CatalogAfterHook
+	streamAfterHooks   []StreamAfterHook
+	metaAfterHooks     []MetaAfterHook
 }
 
 // NewAddon creates a new Addon object that can be started with Run().
@@ -83,10 +128,17 @@ func NewAddon(manifest Manifest, catalogHandlers map[string]CatalogHandler, stre
 	} else if catalogHandlers == nil && streamHandlers == nil {
 		return nil, errors.New("No handler was passed")
 	} else if (opts.CachePublicCatalogs && opts.CacheAgeCatalogs == 0) ||
-		(opts.CachePublicStreams && opts.CacheAgeStreams == 0) {
+		(opts.CachePublicStreams && opts.CacheAgeStreams == 0) ||
+		(opts.CachePublicMeta && opts.CacheAgeMeta == 0) ||
+		(opts.CachePublicSubtitles && opts.CacheAgeSubtitles == 0) {
 		return nil, errors.New("Enabling public caching only makes sense when also setting a cache age")
+	} else if (opts.CacheAgeNotFoundCatalogs != 0 && !opts.NotFoundAsEmptyCatalogs) ||
+		(opts.CacheAgeNotFoundStreams != 0 && !opts.NotFoundAsEmptyStreams) {
+		return nil, errors.New("Setting a cache age for not found responses only makes sense when responding to them with empty results")
 	} else if (opts.HandleEtagCatalogs && opts.CacheAgeCatalogs == 0) ||
-		(opts.HandleEtagStreams && opts.CacheAgeStreams == 0) {
+		(opts.HandleEtagStreams && opts.CacheAgeStreams == 0) ||
+		(opts.HandleEtagMeta && opts.CacheAgeMeta == 0) ||
+		(opts.HandleEtagSubtitles && opts.CacheAgeSubtitles == 0) {
 		return nil, errors.New("ETag handling only makes sense when also setting a cache age")
 	} else if opts.DisableRequestLogging && (opts.LogIPs || opts.LogUserAgent) {
 		return nil, errors.New("Enabling IP or user agent logging doesn't make sense when disabling request logging")
@@ -94,15 +146,35 @@ func NewAddon(manifest Manifest, catalogHandlers map[string]CatalogHandler, stre
 		return nil, errors.New("Setting a logging level in the options doesn't make sense when you already set a custom logger")
 	} else if opts.DisableRequestLogging && opts.LogMediaName {
 		return nil, errors.New("Enabling media name logging doesn't make sense when disabling request logging")
-	} else if opts.MetaClient != nil && !opts.LogMediaName && !opts.PutMetaInContext {
-		return nil, errors.New("Setting a meta client when neither logging the media name nor putting it in the context doesn't make sense")
+	} else if opts.MetaClient != nil && !opts.LogMediaName && !opts.PutMetaInContext && !opts.EnrichCatalogs {
+		return nil, errors.New("Setting a meta client when neither logging the media name nor putting it in the context nor enriching catalogs doesn't make sense")
+	} else if opts.CatalogMetaExtra != "" && !opts.LogMediaName && !opts.PutMetaInContext {
+		return nil, errors.New("Setting a catalog meta extra when neither logging the media name nor putting it in the context doesn't make sense")
+	} else if opts.MetaFromMetaHandlers && opts.MetaClient != nil {
+		return nil, errors.New("Getting meta from the meta handlers doesn't make sense when you already set a meta client")
+	} else if opts.MetaFromMetaHandlers && !opts.LogMediaName && !opts.PutMetaInContext && !opts.EnrichCatalogs {
+		return nil, errors.New("Getting meta from the meta handlers when neither logging the media name nor putting it in the context nor enriching catalogs doesn't make sense")
+	} else if opts.MetaFromMetaHandlers && (opts.CinemetaTimeout != 0 || opts.CinemetaHTTPClient != nil || opts.CinemetaTransport != nil || opts.CinemetaRequestDecorators != nil) {
+		return nil, errors.New("Setting Cinemeta client options doesn't make sense when getting meta from the meta handlers")
 	} else if opts.MetaClient != nil && opts.CinemetaTimeout != 0 {
 		return nil, errors.New("Setting a Cinemeta timeout doesn't make sense when you already set a meta client")
+	} else if opts.MetaClient != nil && opts.CinemetaCacheCapacity != 0 {
+		return nil, errors.New("Setting a Cinemeta cache capacity doesn't make sense when you already set a meta client")
+	} else if opts.MetaClient != nil && (opts.CinemetaHTTPClient != nil || opts.CinemetaTransport != nil || opts.CinemetaRequestDecorators != nil) {
+		return nil, errors.New("Setting a Cinemeta HTTP client, transport or request decorators doesn't make sense when you already set a meta client")
+	} else if opts.CinemetaHTTPClient != nil && (opts.CinemetaTimeout != 0 || opts.CinemetaTransport != nil) {
+		return nil, errors.New("Setting a Cinemeta timeout or transport doesn't make sense when you already set a Cinemeta HTTP client")
 	} else if manifest.BehaviorHints.ConfigurationRequired && !manifest.BehaviorHints.Configurable {
 		return nil, errors.New("Requiring a configuration only makes sense when also making the addon configurable")
 	} else if opts.ConfigureHTMLfs != nil && !manifest.BehaviorHints.Configurable {
 		return nil, errors.New("Setting a ConfigureHTMLfs only makes sense when also making the addon configurable")
 		// Note: The other way around is fine: We allow an addon creator to make the addon configurable, but then add his own "/configure" endpoint.
+	} else if opts.GenerateConfigurePage && !manifest.BehaviorHints.Configurable {
+		return nil, errors.New("Generating a configure page only makes sense when also making the addon configurable")
+	} else if opts.GenerateConfigurePage && opts.ConfigureHTMLfs != nil {
+		return nil, errors.New("Generating a configure page doesn't make sense when you already set a ConfigureHTMLfs")
+	} else if opts.ConfigStore != nil && (opts.UserDataIsBase64 || opts.UserDataIsCompressed) {
+		return nil, errors.New("Base64-encoded or compressed user data doesn't make sense when using a config store, because the user data is a config ID then")
 	}
 
 	// Set default values
@@ -118,9 +190,16 @@ func NewAddon(manifest Manifest, catalogHandlers map[string]CatalogHandler, stre
 	if opts.LogEncoding == "" {
 		opts.LogEncoding = DefaultOptions.LogEncoding
 	}
-	if opts.CinemetaTimeout == 0 {
+	// With a custom HTTP client, the Cinemeta client uses its timeout
+	if opts.CinemetaTimeout == 0 && opts.CinemetaHTTPClient == nil && !opts.MetaFromMetaHandlers {
 		opts.CinemetaTimeout = DefaultOptions.CinemetaTimeout
 	}
+	if opts.CinemetaCacheCapacity == 0 {
+		opts.CinemetaCacheCapacity = DefaultOptions.CinemetaCacheCapacity
+	}
+	if opts.CacheAgeNotices == 0 {
+		opts.CacheAgeNotices = DefaultOptions.CacheAgeNotices
+	}
 
 	// Configure logger if no custom one is set
 	if opts.Logger == nil {
@@ -130,12 +209,19 @@ func NewAddon(manifest Manifest, catalogHandlers map[string]CatalogHandler, stre
 		}
 	}
 	// Configure Cinemeta client if no custom MetaFetcher is set
-	if opts.MetaClient == nil && (opts.LogMediaName || opts.PutMetaInContext) {
-		cinemetaCache := cinemeta.NewInMemoryCache()
+	// With MetaFromMetaHandlers the MetaFetcher is created when running the addon, because the meta handlers are set after creating it.
+	if opts.MetaClient == nil && !opts.MetaFromMetaHandlers && (opts.LogMediaName || opts.PutMetaInContext || opts.EnrichCatalogs) {
+		// Items expire with the TTL that the client sets them with, for example the shorter one of "not found" results
+		cinemetaCache := cinemeta.NewLRUCache(opts.CinemetaCacheCapacity, 0)
 		cinemetaOpts := cinemeta.ClientOptions{
-			Timeout: opts.CinemetaTimeout,
+			Timeout:           opts.CinemetaTimeout,
+			HTTPClient:        opts.CinemetaHTTPClient,
+			Transport:         opts.CinemetaTransport,
+			RequestDecorators: opts.CinemetaRequestDecorators,
 		}
-		opts.MetaClient = cinemeta.NewClient(cinemetaOpts, cinemetaCache, opts.Logger)
+		// Cinemeta only knows IMDb IDs, so other IDs shouldn't lead to requests to Cinemeta
+		cinemetaClient := cinemeta.NewClientV2(cinemetaOpts, cinemetaCache, opts.Logger)
+		opts.MetaClient = NewMetaRouter(map[string]MetaFetcher{IDSchemeIMDb: cinemetaClient}, nil)
 	}
 
 	// Create and return addon
@@ -159,6 +245,19 @@ func (a *Addon) RegisterUserData(userDataObject interface{}) {
 	a.userDataType = t
 }
 
+// RegisterUserDataMigration registers a migration from the given user data schema version to the next one.
+// The version is read from the "version" field of the user data JSON object, which you should add to your user data type.
+// User data without a version field is treated as version 0.
+// When decoding user data, go-stremio applies all migrations starting with the version of the user data,
+// so URLs that were installed with an older schema keep working after changing the user data type.
+// For example if your current schema is version 2, you register migrations for version 0 and 1.
+func (a *Addon) RegisterUserDataMigration(version int, migration UserDataMigration) {
+	if a.userDataMigrations == nil {
+		a.userDataMigrations = make(map[int]UserDataMigration)
+	}
+	a.userDataMigrations[version] = migration
+}
+
 // DecodeUserData decodes the request's user data and returns the result.
 // It's useful when you add custom endpoints to the addon that don't have a userData parameter
 // like the ManifestCallback, CatalogHandler and StreamHandler have.
@@ -166,7 +265,54 @@ func (a *Addon) RegisterUserData(userDataObject interface{}) {
 // for example when using `AddEndpoint("GET", "/:userData/ping", customEndpoint)` you must pass "userData".
 func (a *Addon) DecodeUserData(param string, c *fiber.Ctx) (interface{}, error) {
 	data := c.Params(param, "")
-	return decodeUserData(data, a.userDataType, a.logger, a.opts.UserDataIsBase64)
+	return decodeUserData(c.Context(), data, a.userDataType, a.logger, a.opts.UserDataIsBase64, a.opts.ConfigStore, a.userDataMigrations)
+}
+
+// EncodeUserData marshals the user data to JSON and encodes it the way the addon decodes it,
+// depending on the UserDataIsBase64 and UserDataIsCompressed options.
+// The result can be used as "userData" URL parameter, for example in install URLs created on a configure page.
+// When using a ConfigStore the user data in URLs is a config ID instead, so this method returns an error then.
+func (a *Addon) EncodeUserData(userData interface{}) (string, error) {
+	if a.opts.ConfigStore != nil {
+		return "", errors.New("User data can't be encoded when using a config store, store it and use its config ID instead")
+	}
+	return encodeUserData(userData, a.opts.UserDataIsBase64, a.opts.UserDataIsCompressed)
+}
+
+// metaItemFromHandlers is the MetaItemFunc for the MetaFromMetaHandlers option.
+// It calls the meta handler for the type without user data, which is an empty string or nil like in requests without user data.
+func (a *Addon) metaItemFromHandlers(ctx context.Context, t, id string) (MetaItem, error) {
+	metaHandler, ok := a.metaHandlers[t]
+	if !ok {
+		return MetaItem{}, fmt.Errorf("No meta handler for type %q", t)
+	}
+	var userData interface{}
+	if a.userDataType == nil {
+		userData = ""
+	}
+	return metaHandler(ctx, id, userData)
+}
+
+// currentUserDataJSON decodes the request's user data and returns it as JSON.
+// Registered user data is migrated to the latest version, so configure pages only have to deal with the latest schema.
+// Unregistered user data is returned as JSON string.
+func (a *Addon) currentUserDataJSON(c *fiber.Ctx) ([]byte, error) {
+	userDataString := c.Params("userData")
+	if a.userDataType == nil {
+		if a.opts.ConfigStore != nil {
+			config, err := resolveConfigID(c.Context(), userDataString, a.opts.ConfigStore, a.logger)
+			if err != nil {
+				return nil, err
+			}
+			userDataString = string(config)
+		}
+		return json.Marshal(userDataString)
+	}
+	userData, err := decodeUserData(c.Context(), userDataString, a.userDataType, a.logger, a.opts.UserDataIsBase64, a.opts.ConfigStore, a.userDataMigrations)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(userData)
 }
 
 // AddMiddleware appends a custom middleware to the chain of existing middlewares.
@@ -199,6 +345,18 @@ func (a *Addon) SetManifestCallback(callback ManifestCallback) {
 	a.manifestCallback = callback
 }
 
+// SetMetaHandlers sets the handlers for meta requests, with the type (like "movie") as key.
+// Add the "meta" resource to the manifest, so that Stremio sends the requests.
+func (a *Addon) SetMetaHandlers(metaHandlers map[string]MetaHandler) {
+	a.metaHandlers = metaHandlers
+}
+
+// SetSubtitlesHandlers sets the handlers for subtitles requests, with the type (like "movie") as key.
+// Add the "subtitles" resource to the manifest, so that Stremio sends the requests.
+func (a *Addon) SetSubtitlesHandlers(subtitlesHandlers map[string]SubtitlesHandler) {
+	a.subtitlesHandlers = subtitlesHandlers
+}
+
 // Run starts the remote addon. It sets up an HTTP server that handles requests to "/manifest.json" etc. and gracefully handles shutdowns.
 // The call is *blocking*, so use the stoppingChan param if you want to be notified when the addon is about to shut down
 // because of a system signal like Ctrl+C or `docker stop`. It should be a buffered channel with a capacity of 1.
@@ -211,6 +369,45 @@ func (a *Addon) Run(stoppingChan chan bool) {
 		logger.Fatal("The passed stopping channel isn't buffered")
 	}
 
+	app := a.createApp()
+
+	stopping := false
+	stoppingPtr := &stopping
+
+	addr := a.opts.BindAddr + ":" + strconv.Itoa(a.opts.Port)
+	logger.Info("Starting server", zap.String("address", addr))
+	go func() {
+		if err := app.Listen(addr); err != nil {
+			if !*stoppingPtr {
+				logger.Fatal("Couldn't start server", zap.Error(err))
+			} else {
+				logger.Fatal("Error in srv.ListenAndServe() during server shutdown (probably context deadline expired before the server could shutdown cleanly)", zap.Error(err))
+			}
+		}
+	}()
+
+	// Graceful shutdown
+
+	c := make(chan os.Signal, 1)
+	// Accept SIGINT (Ctrl+C) and SIGTERM (`docker stop`)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	logger.Info("Received signal, shutting down server...", zap.Stringer("signal", sig))
+	*stoppingPtr = true
+	if stoppingChan != nil {
+		stoppingChan <- true
+	}
+	// Graceful shutdown, waiting for all current requests to finish without accepting new ones.
+	if err := app.Shutdown(); err != nil {
+		logger.Fatal("Error shutting down server", zap.Error(err))
+	}
+	logger.Info("Finished shutting down server")
+}
+
+// createApp creates the Fiber app with all middlewares and endpoints, without starting it.
+func (a *Addon) createApp() *fiber.App {
+	logger := a.logger
+
 	// Fiber app
 
 	logger.Info("Setting up server...")
@@ -244,14 +441,39 @@ func (a *Addon) Run(stoppingChan chan bool) {
 		app.Use(createMetricsMiddleware())
 	}
 	app.Use(corsMiddleware()) // Stremio doesn't show stream responses when no CORS middleware is used!
+	if a.opts.MetaFromMetaHandlers {
+		if a.metaHandlers == nil {
+			logger.Fatal("Getting meta from the meta handlers requires meta handlers")
+		}
+		metaCacheAge := a.opts.CacheAgeMeta
+		if metaCacheAge == 0 {
+			metaCacheAge = time.Hour
+		}
+		a.metaClient = NewMetaItemFetcher(a.metaItemFromHandlers, a.opts.CinemetaCacheCapacity, metaCacheAge)
+	}
+
 	// Filter some requests (like for requests without user data when the addon requires configuration, or for missing type or id URL parameters) and put some request info in the context
 	addRouteMatcherMiddleware(app, a.manifest.BehaviorHints.ConfigurationRequired, a.opts.StreamIDregex, logger)
-	metaMw := createMetaMiddleware(a.metaClient, a.opts.PutMetaInContext, a.opts.LogMediaName, logger)
-	// Meta middleware only works for stream requests.
-	if !a.manifest.BehaviorHints.ConfigurationRequired {
-		app.Use("/stream/:type/:id.json", metaMw)
+	// Meta middleware only works for requests for a specific movie or TV show
+	if a.opts.LogMediaName || a.opts.PutMetaInContext {
+		metaMwPaths := map[string][]string{
+			"stream":    {"/stream/:type/:id.json"},
+			"subtitles": {"/subtitles/:type/:id.json", "/subtitles/:type/:id/:extra.json"},
+			"meta":      {"/meta/:type/:id.json"},
+		}
+		if a.opts.CatalogMetaExtra != "" {
+			metaMwPaths["catalog"] = []string{"/catalog/:type/:id/:extra.json"}
+		}
+		for resource, paths := range metaMwPaths {
+			metaMw := createMetaMiddleware(a.metaClient, a.opts.PutMetaInContext, a.opts.LogMediaName, resource, a.opts.CatalogMetaExtra, logger)
+			for _, path := range paths {
+				if !a.manifest.BehaviorHints.ConfigurationRequired {
+					app.Use(path, metaMw)
+				}
+				app.Use("/:userData"+path, metaMw)
+			}
+		}
 	}
-	app.Use("/:userData/stream/:type/:id.json", metaMw)
 	// Custom middlewares
 	for _, customMW := range a.customMiddlewares {
 		app.Use(customMW.path, customMW.mw)
@@ -285,40 +507,120 @@ func (a *Addon) Run(stoppingChan chan bool) {
 	// Stremio endpoints
 
 	// In Fiber optional parameters don't work at the beginning of the URL, so we have to register two routes each
-	manifestHandler := createManifestHandler(a.manifest, logger, a.manifestCallback, a.userDataType, a.opts.UserDataIsBase64)
+	manifestHandler := createManifestHandler(a.manifest, logger, a.manifestCallback, a.userDataType, a.opts.UserDataIsBase64, a.opts.ConfigStore, a.userDataMigrations)
 	// We always register this route, because even if BehaviorHints.ConfigurationRequired is true, this endpoint is required for the addon to be listed in Stremio's community addons.
 	app.Get("/manifest.json", manifestHandler)
 	app.Get("/:userData/manifest.json", manifestHandler)
+	// Notices link to the configure page by default, if there is one
+	hasConfigurePage := a.opts.ConfigureHTMLfs != nil || a.opts.GenerateConfigurePage
+	baseHandlerOpts := handlerOptions{
+		logger:           logger,
+		userDataType:     a.userDataType,
+		userDataIsBase64: a.opts.UserDataIsBase64,
+		configStore:      a.opts.ConfigStore,
+		migrations:       a.userDataMigrations,
+		defaultNoticeURL: a.opts.NoticeURL,
+		noticeCacheAge:   a.opts.CacheAgeNotices,
+		hasConfigurePage: hasConfigurePage,
+		beforeHooks:      a.beforeHooks,
+		putMetaInContext: a.opts.PutMetaInContext,
+	}
 	if a.catalogHandlers != nil {
-		catalogHandler := createCatalogHandler(a.catalogHandlers, a.opts.CacheAgeCatalogs, a.opts.CachePublicCatalogs, a.opts.HandleEtagCatalogs, logger, a.userDataType, a.opts.UserDataIsBase64)
+		var enrichMetaClient MetaFetcher
+		if a.opts.EnrichCatalogs {
+			enrichMetaClient = a.metaClient
+		}
+		handlerOpts := baseHandlerOpts
+		handlerOpts.cacheAge = a.opts.CacheAgeCatalogs
+		handlerOpts.cachePublic = a.opts.CachePublicCatalogs
+		handlerOpts.handleEtag = a.opts.HandleEtagCatalogs
+		handlerOpts.notFoundAsEmpty = a.opts.NotFoundAsEmptyCatalogs
+		handlerOpts.notFoundCacheAge = a.opts.CacheAgeNotFoundCatalogs
+		catalogHandler := createCatalogHandler(a.catalogHandlers, handlerOpts, enrichMetaClient, a.catalogAfterHooks)
 		if !a.manifest.BehaviorHints.ConfigurationRequired {
 			app.Get("/catalog/:type/:id.json", catalogHandler)
+			app.Get("/catalog/:type/:id/:extra.json", catalogHandler)
 		}
-		// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
+		// We always register these routes, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
 		app.Get("/:userData/catalog/:type/:id.json", catalogHandler)
+		app.Get("/:userData/catalog/:type/:id/:extra.json", catalogHandler)
 	}
 	if a.streamHandlers != nil {
-		streamHandler := createStreamHandler(a.streamHandlers, a.opts.CacheAgeStreams, a.opts.CachePublicStreams, a.opts.HandleEtagStreams, logger, a.userDataType, a.opts.UserDataIsBase64)
+		handlerOpts := baseHandlerOpts
+		handlerOpts.cacheAge = a.opts.CacheAgeStreams
+		handlerOpts.cachePublic = a.opts.CachePublicStreams
+		handlerOpts.handleEtag = a.opts.HandleEtagStreams
+		handlerOpts.notFoundAsEmpty = a.opts.NotFoundAsEmptyStreams
+		handlerOpts.notFoundCacheAge = a.opts.CacheAgeNotFoundStreams
+		streamHandler := createStreamHandler(a.streamHandlers, handlerOpts, a.streamAfterHooks)
 		if !a.manifest.BehaviorHints.ConfigurationRequired {
 			app.Get("/stream/:type/:id.json", streamHandler)
 		}
 		// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
 		app.Get("/:userData/stream/:type/:id.json", streamHandler)
 	}
+	if a.metaHandlers != nil {
+		handlerOpts := baseHandlerOpts
+		handlerOpts.cacheAge = a.opts.CacheAgeMeta
+		handlerOpts.cachePublic = a.opts.CachePublicMeta
+		handlerOpts.handleEtag = a.opts.HandleEtagMeta
+		metaHandler := createMetaHandler(a.metaHandlers, handlerOpts, a.metaAfterHooks)
+		if !a.manifest.BehaviorHints.ConfigurationRequired {
+			app.Get("/meta/:type/:id.json", metaHandler)
+		}
+		app.Get("/:userData/meta/:type/:id.json", metaHandler)
+	}
+	if a.subtitlesHandlers != nil {
+		handlerOpts := baseHandlerOpts
+		handlerOpts.cacheAge = a.opts.CacheAgeSubtitles
+		handlerOpts.cachePublic = a.opts.CachePublicSubtitles
+		handlerOpts.handleEtag = a.opts.HandleEtagSubtitles
+		subtitlesHandler := createSubtitlesHandler(a.subtitlesHandlers, handlerOpts)
+		if !a.manifest.BehaviorHints.ConfigurationRequired {
+			app.Get("/subtitles/:type/:id.json", subtitlesHandler)
+			app.Get("/subtitles/:type/:id/:extra.json", subtitlesHandler)
+		}
+		app.Get("/:userData/subtitles/:type/:id.json", subtitlesHandler)
+		app.Get("/:userData/subtitles/:type/:id/:extra.json", subtitlesHandler)
+	}
 	if a.opts.ConfigureHTMLfs != nil {
 		fsConfig := filesystem.Config{
 			Root: a.opts.ConfigureHTMLfs,
 		}
 		app.Use("/configure", filesystem.New(fsConfig))
 		// When a Stremio user has the addon already installed and configures it again, this endpoint is called,
-		// theoretically enabling the addon to deliver a website with the configuration fields populated with the currently configured values.
-		// The Fiber filesystem middleware currently doesn't work with parameters in the route (see https://github.com/gofiber/fiber/issues/834),
-		// so we'll just redirect to the original one, as we don't use the existing configuration anyway.
-		// TODO: At some point we should populate the config fields with the existing configuration.
-		app.Get("/:userData/configure", func(c *fiber.Ctx) error {
-			c.Set("Location", c.BaseURL()+"/configure")
-			return c.SendStatus(fiber.StatusMovedPermanently)
-		})
+		// enabling the addon to deliver a website with the configuration fields populated with the currently configured values.
+		// The current user data is injected into the page as global JavaScript variable.
+		app.Get("/:userData/configure", createConfigureFSHandler(a.opts.ConfigureHTMLfs, a.currentUserDataJSON, logger))
+	}
+	if a.opts.GenerateConfigurePage {
+		if a.userDataType == nil {
+			logger.Fatal("Generating a configure page requires a registered user data type")
+		}
+		fields, err := configureFieldsFromType(a.userDataType, latestUserDataVersion(a.userDataMigrations))
+		if err != nil {
+			logger.Fatal("Couldn't create configure page fields from user data type", zap.Error(err))
+		}
+		configurePageHandler := createConfigurePageHandler(a.manifest, fields, a.currentUserDataJSON, logger)
+		app.Get("/configure", configurePageHandler)
+		app.Get("/:userData/configure", configurePageHandler)
+	}
+	// Configure pages can fetch the current user data for populating their fields, as alternative to the injected JavaScript variable
+	if a.opts.ConfigureHTMLfs != nil || a.opts.GenerateConfigurePage {
+		app.Get("/:userData/configure/data.json", createConfigureDataHandler(a.currentUserDataJSON, logger))
+	}
+	// Configure pages can turn a config into user data for the install URL, which creates or updates server-side stored configs when using a config store
+	if a.opts.ConfigStore != nil || a.opts.GenerateConfigurePage {
+		configureSubmitHandler := createConfigureSubmitHandler(a.opts.ConfigStore, a.userDataType, a.userDataMigrations, a.opts.UserDataIsBase64, a.opts.UserDataIsCompressed, logger)
+		app.Post("/configure", configureSubmitHandler)
+		app.Post("/:userData/configure", configureSubmitHandler)
+	}
+
+	// Install links
+	if a.opts.InstallEndpoint {
+		installHandler := createInstallHandler(a.manifest.BehaviorHints.ConfigurationRequired, logger)
+		app.Get("/install", installHandler)
+		app.Get("/:userData/install", installHandler)
 	}
 
 	// Additional endpoints
@@ -335,35 +637,5 @@ func (a *Addon) Run(stoppingChan chan bool) {
 
 	logger.Info("Finished setting up server")
 
-	stopping := false
-	stoppingPtr := &stopping
-
-	addr := a.opts.BindAddr + ":" + strconv.Itoa(a.opts.Port)
-	logger.Info("Starting server", zap.String("address", addr))
-	go func() {
-		if err := app.Listen(addr); err != nil {
-			if !*stoppingPtr {
-				logger.Fatal("Couldn't start server", zap.Error(err))
-			} else {
-				logger.Fatal("Error in srv.ListenAndServe() during server shutdown (probably context deadline expired before the server could shutdown cleanly)", zap.Error(err))
-			}
-		}
-	}()
-
-	// Graceful shutdown
-
-	c := make(chan os.Signal, 1)
-	// Accept SIGINT (Ctrl+C) and SIGTERM (`docker stop`)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	sig := <-c
-	logger.Info("Received signal, shutting down server...", zap.Stringer("signal", sig))
-	*stoppingPtr = true
-	if stoppingChan != nil {
-		stoppingChan <- true
-	}
-	// Graceful shutdown, waiting for all current requests to finish without accepting new ones.
-	if err := app.Shutdown(); err != nil {
-		logger.Fatal("Error shutting down server", zap.Error(err))
-	}
-	logger.Info("Finished shutting down server")
+	return app
 }
@@ -64,11 +64,19 @@ type Options struct {
 	CacheAgeCatalogs time.Duration
 	// Same as CacheAgeCatalogs, but for streams.
 	CacheAgeStreams time.Duration
+	// Same as CacheAgeCatalogs, but for meta.
+	CacheAgeMeta time.Duration
+	// Same as CacheAgeCatalogs, but for subtitles.
+	CacheAgeSubtitles time.Duration
 	// Flag for indicating to proxies whether they are allowed to cache responses from the catalog endpoint.
 	// Default false.
 	CachePublicCatalogs bool
 	// Same as CachePublicCatalogs, but for streams.
 	CachePublicStreams bool
+	// Same as CachePublicCatalogs, but for meta.
+	CachePublicMeta bool
+	// Same as CachePublicCatalogs, but for subtitles.
+	CachePublicSubtitles bool
 	// Flag for indicating whether the "ETag" header should be set and the "If-None-Match" header checked.
 	// Helps reducing the transferred data volume from the server even further.
 	// Only makes sense when setting a non-zero CacheAgeCatalogs.
@@ -77,37 +85,144 @@ type Options struct {
 	HandleEtagCatalogs bool
 	// Same as HandleEtagCatalogs, but for streams.
 	HandleEtagStreams bool
+	// Same as HandleEtagCatalogs, but for meta.
+	HandleEtagMeta bool
+	// Same as HandleEtagCatalogs, but for subtitles.
+	HandleEtagSubtitles bool
+	// Flag for indicating whether a NotFound error from the CatalogHandler should lead to an empty "200 OK" response (`{"metas":[]}`)
+	// instead of "404 Not Found". Stremio and caching proxies handle empty results better than 404 responses.
+	// The empty response is logged at debug level instead of as warning.
+	// Default false.
+	NotFoundAsEmptyCatalogs bool
+	// Same as NotFoundAsEmptyCatalogs, but for streams (`{"streams":[]}`).
+	NotFoundAsEmptyStreams bool
+	// Duration of client/proxy-side cache for the empty responses to NotFound errors from the CatalogHandler.
+	// Only makes sense when setting NotFoundAsEmptyCatalogs.
+	// Whether proxies are allowed to cache the responses depends on CachePublicCatalogs.
+	// Default 0.
+	CacheAgeNotFoundCatalogs time.Duration
+	// Same as CacheAgeNotFoundCatalogs, but for streams.
+	CacheAgeNotFoundStreams time.Duration
+	// URL that's opened when users click on a Notice that handlers return, if the Notice doesn't have its own URL.
+	// If empty, the addon's configure page with the user's data is used, if it has one, or the addon's root URL otherwise.
+	// Default "".
+	NoticeURL string
+	// Duration of client-side cache for responses with a Notice.
+	// They're always marked as private, because notices are usually specific to a user.
+	// Default 1 minute.
+	CacheAgeNotices time.Duration
 	// Flag for indicating whether user data is Base64-encoded.
 	// As the user data is in the URL it needs to be the URL-safe Base64 encoding described in RFC 4648.
 	// When true, go-stremio first decodes the value before passing or unmarshalling it.
 	// Default false.
 	UserDataIsBase64 bool
+	// Flag for indicating whether user data is compressed with DEFLATE before being URL-safe Base64-encoded.
+	// Useful for large configurations, which would otherwise lead to URLs that some clients and proxies truncate.
+	// Compressed user data is prefixed with "d.", so go-stremio detects it when decoding, independent of this flag.
+	// This means that URLs created before switching between encodings keep working.
+	// The flag determines how `Addon.EncodeUserData()` encodes user data. It takes precedence over UserDataIsBase64.
+	// Default false.
+	UserDataIsCompressed bool
+	// Store for user data that's stored server-side.
+	// When set, the user data in the URL is only a short config ID, which is resolved via the store before being decoded.
+	// This keeps install URLs short and allows users to change their config without reinstalling the addon.
+	// Configs can be created by POSTing the JSON to "/configure" and updated by POSTing it to "/:userData/configure".
+	// The response contains the config ID as "id" field.
+	// Can't be combined with UserDataIsBase64 or UserDataIsCompressed.
+	// Default nil.
+	ConfigStore ConfigStore
 	// Flag for indicating whether to look up the movie / TV show name by its IMDb ID and put it into the context.
-	// Only works for stream requests.
+	// Handlers can get it with `cinemeta.GetMetaFromContext()`, custom middlewares and endpoints with `GetMetaFromFiberCtx()`.
+	// Works for stream, subtitles and meta requests, and for catalog requests with the extra that's set as CatalogMetaExtra.
 	// Default false.
 	PutMetaInContext bool
 	// Flag for indicating whether to include the movie / TV show name (and year) in the request log.
-	// Only works for stream requests.
+	// Works for the same requests as PutMetaInContext.
 	// Default false.
 	LogMediaName bool
-	// Meta client for fetching movie and TV show info.
+	// Name of a catalog extra whose value is a movie or TV show ID, for example for catalogs of similar movies.
+	// For catalog requests with this extra, PutMetaInContext and LogMediaName work like for meta requests.
 	// Only relevant when using PutMetaInContext or LogMediaName.
+	// Default "" (no meta for catalog requests).
+	CatalogMetaExtra string
+	// Flag for indicating whether to fill catalog items that don't have a name with the movie / TV show info from the MetaClient.
+	// Your CatalogHandler can then return items with only the ID and type set, and go-stremio fills in
+	// the name, poster, genres, IMDb rating, release info and description concurrently before responding.
+	// Fields that your handler already set are kept.
+	// Default false.
+	EnrichCatalogs bool
+	// Meta client for fetching movie and TV show info.
+	// Only relevant when using PutMetaInContext, LogMediaName or EnrichCatalogs.
 	// You can set it if you have already created one to share its in-memory cache for example,
 	// or leave it empty to let go-stremio create a client that fetches metadata from Stremio's Cinemeta remote addon.
+	// For IDs other than IMDb IDs (like "kitsu:123:4") you can use a MetaRouter and combine MetaFetchers with a MetaFetcherChain.
+	// If your addon serves meta for its own IDs, you can use a MetaItemFetcher to use that as source, or set MetaFromMetaHandlers.
 	MetaClient MetaFetcher
-	// Timeout for requests to Cinemeta.
-	// Only relevant when using PutMetaInContext or LogMediaName.
+	// Flag for indicating whether to get the meta for PutMetaInContext, LogMediaName and EnrichCatalogs from the addon's own meta handlers
+	// (see `SetMetaHandlers()`) instead of Cinemeta, for addons that serve meta for their own IDs.
+	// The handlers are called in-process, without user data and without before hooks.
+	// Their results are cached for CacheAgeMeta (or 1 hour if it's 0), in a cache with CinemetaCacheCapacity as capacity.
+	// Can't be combined with MetaClient or the Cinemeta client options.
+	// Default false.
+	MetaFromMetaHandlers bool
+	// Timeout for requests to Cinemeta, including the client's retries of failed requests,
+	// so this is the longest time that a handler (with PutMetaInContext) waits for meta.
+	// Only relevant when using PutMetaInContext, LogMediaName or EnrichCatalogs.
 	// Only required when not setting a MetaClient in the options already.
 	// Note that each response is cached for 30 days, so waiting a bit once per movie / TV show per 30 days is acceptable.
 	// Default 2 seconds.
 	CinemetaTimeout time.Duration
+	// Maximum number of movies / TV shows in the in-memory cache of the Cinemeta client.
+	// With MetaFromMetaHandlers it's the capacity of the cache for the meta handlers' results.
+	// When the cache is full, the least recently used item is evicted.
+	// Only relevant when using PutMetaInContext, LogMediaName or EnrichCatalogs.
+	// Only required when not setting a MetaClient in the options already.
+	// Default 5000.
+	CinemetaCacheCapacity int
+	// HTTP client for requests to Cinemeta, for example with a proxy or custom connection limits.
+	// Only relevant when using PutMetaInContext, LogMediaName or EnrichCatalogs.
+	// Only allowed when not setting a MetaClient in the options already.
+	// Can't be combined with CinemetaTimeout or CinemetaTransport, configure them in the HTTP client instead.
+	// Its timeout is then used like CinemetaTimeout, so it also applies to all retries together.
+	// Default nil.
+	CinemetaHTTPClient *http.Client
+	// Transport for the HTTP client of the Cinemeta client, for example for tracing.
+	// Only relevant when using PutMetaInContext, LogMediaName or EnrichCatalogs.
+	// Only allowed when not setting a MetaClient or CinemetaHTTPClient in the options already.
+	// Default nil (http.DefaultTransport).
+	CinemetaTransport http.RoundTripper
+	// Functions that are called for each request to Cinemeta before sending it.
+	// They can modify the request, for example to set a User-Agent header identifying your addon.
+	// Only relevant when using PutMetaInContext, LogMediaName or EnrichCatalogs.
+	// Only allowed when not setting a MetaClient in the options already.
+	// Default nil.
+	CinemetaRequestDecorators []func(*http.Request)
 	// "File system" with HTML files that will be served for the "/configure" endpoint.
 	// Typically an `http.Dir`, which you can simply create with `http.Dir("/path/to/html/files")`.
 	// For using it with Go's embedding feature, you can either use `http.FS(embedFS)` directly,
 	// or if the directory doesn't match the URL path you can use `stremio.PrefixedFS`.
+	// The "index.html" is also served for "/:userData/configure" requests, which Stremio sends when reconfiguring an installed addon.
+	// In that case the current user data is injected as global JavaScript variable `window.stremioUserData`,
+	// so you can populate the configuration fields with it. It's also available via "/:userData/configure/data.json".
 	// No configure endpoint will be created if this is nil, so you can add a custom one.
 	// Default nil.
 	ConfigureHTMLfs http.FileSystem
+	// Flag for indicating whether to serve a generated configure page for the "/configure" endpoint.
+	// The page contains a form field for each field of the type registered with `RegisterUserData()`,
+	// configured via struct tags: `label:"..."`, `help:"..."`, `options:"a,b,c"`, `required:"true"` and `secret:"true"`.
+	// Supported field types are strings, string slices (with options), bools, ints and floats.
+	// When submitted, the page shows the install link with properly encoded user data, so no custom HTML is needed.
+	// Can't be combined with ConfigureHTMLfs.
+	// Default false.
+	GenerateConfigurePage bool
+	// Flag for indicating whether to create the "/install" and "/:userData/install" endpoints,
+	// which redirect to the link for installing the addon, so that landing and configure pages don't have to create it themselves.
+	// The "target" query parameter selects the link: "stremio" (default) for the "stremio://" deep link,
+	// "web" for Stremio Web and "manifest" for the manifest URL.
+	// If the addon requires a configuration, "/install" redirects to "/configure".
+	// See `Addon.InstallLinks()` for creating the links in your own code.
+	// Default false.
+	InstallEndpoint bool
 	// Regex for accepted stream IDs.
 	// Even when setting the "tt" prefix in the manifest to only allow IMDb IDs, some clients still send stream requests for completely different IDs,
 	// potentially leading to your handlers being triggered and executing some logic before than failing due to the bad ID.
@@ -121,9 +236,11 @@ type Options struct {
 // DefaultOptions is an Options object with default values.
 // For fields that aren't set here the zero value is the default value.
 var DefaultOptions = Options{
-	BindAddr:        "localhost",
-	Port:            8080,
-	LoggingLevel:    "info",
-	LogEncoding:     "console",
-	CinemetaTimeout: 2 * time.Second,
+	BindAddr:              "localhost",
+	Port:                  8080,
+	LoggingLevel:          "info",
+	LogEncoding:           "console",
+	CinemetaTimeout:       2 * time.Second,
+	CinemetaCacheCapacity: 5000,
+	CacheAgeNotices:       time.Minute,
 }
@@ -0,0 +1,153 @@
+package stremio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// ConfigStore is the interface that go-stremio uses for storing user data server-side.
+// When a ConfigStore is set in the options, the "userData" URL parameter doesn't contain the user data itself,
+// but a short opaque config ID, which is resolved via the store before the user data is decoded.
+// The stored value is the user data's plain JSON (not Base64 or URL encoded).
+// Example implementations are the InMemoryConfigStore and the FileConfigStore in this package.
+type ConfigStore interface {
+	// Get returns the stored config for the given ID.
+	// The boolean return value signals if the config was found in the store.
+	Get(ctx context.Context, id string) ([]byte, bool, error)
+	// Set creates or overwrites the config for the given ID.
+	Set(ctx context.Context, id string, config []byte) error
+}
+
+var (
+	_ ConfigStore = (*InMemoryConfigStore)(nil)
+	_ ConfigStore = (*FileConfigStore)(nil)
+)
+
+// ErrInvalidConfigID signals that a config ID contains characters that aren't allowed.
+// Config IDs must only contain characters of the URL-safe Base64 alphabet.
+var ErrInvalidConfigID = errors.New("invalid config ID")
+
+var configIDregex = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// NewConfigID creates a new random config ID.
+// The ID is URL-safe and short enough to keep install URLs short.
+func NewConfigID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Couldn't read random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// InMemoryConfigStore is an example implementation of the ConfigStore interface.
+// It doesn't persist its data, so all installations become invalid when the addon restarts.
+type InMemoryConfigStore struct {
+	configs map[string][]byte
+	lock    *sync.RWMutex
+}
+
+// NewInMemoryConfigStore creates a new InMemoryConfigStore.
+func NewInMemoryConfigStore() *InMemoryConfigStore {
+	return &InMemoryConfigStore{
+		configs: map[string][]byte{},
+		lock:    &sync.RWMutex{},
+	}
+}
+
+// Get returns the config for the given ID from the store.
+func (s *InMemoryConfigStore) Get(_ context.Context, id string) ([]byte, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	config, found := s.configs[id]
+	return config, found, nil
+}
+
+// Set stores the config under the given ID.
+func (s *InMemoryConfigStore) Set(_ context.Context, id string, config []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.configs[id] = config
+	return nil
+}
+
+// FileConfigStore is a ConfigStore that stores each config as JSON file in a directory.
+// Files are written atomically, so a crash during a write never leaves a corrupted config behind.
+type FileConfigStore struct {
+	dir  string
+	lock *sync.RWMutex
+}
+
+// NewFileConfigStore creates a new FileConfigStore.
+// The directory is created if it doesn't exist yet.
+func NewFileConfigStore(dir string) (*FileConfigStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Couldn't create config directory: %w", err)
+	}
+	return &FileConfigStore{
+		dir:  dir,
+		lock: &sync.RWMutex{},
+	}, nil
+}
+
+// Get reads the config for the given ID from its file.
+// Invalid IDs are treated as not found.
+func (s *FileConfigStore) Get(_ context.Context, id string) ([]byte, bool, error) {
+	if !configIDregex.MatchString(id) {
+		return nil, false, nil
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	config, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("Couldn't read config file: %w", err)
+	}
+	return config, true, nil
+}
+
+// Set writes the config for the given ID to its file.
+// It first writes to a temporary file and then renames it.
+func (s *FileConfigStore) Set(_ context.Context, id string, config []byte) error {
+	if !configIDregex.MatchString(id) {
+		return ErrInvalidConfigID
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return writeFileAtomic(s.dir, s.path(id), config)
+}
+
+func (s *FileConfigStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// writeFileAtomic writes data to a temporary file in dir and then renames it to path.
+func writeFileAtomic(dir, path string, data []byte) error {
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("Couldn't create temporary file: %w", err)
+	}
+	tmpPath := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Couldn't write temporary file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Couldn't rename temporary file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package stremio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileConfigStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileConfigStore(dir)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	id, err := NewConfigID()
+	require.NoError(t, err)
+	require.Regexp(t, configIDregex, id)
+
+	_, found, err := s.Get(ctx, id)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, s.Set(ctx, id, []byte(`{"foo":"bar"}`)))
+	config, found, err := s.Get(ctx, id)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, `{"foo":"bar"}`, string(config))
+
+	// Overwriting must not leave temporary files behind
+	require.NoError(t, s.Set(ctx, id, []byte(`{"foo":"baz"}`)))
+	config, _, err = s.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, `{"foo":"baz"}`, string(config))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// IDs that could escape the directory must be rejected
+	require.ErrorIs(t, s.Set(ctx, "../foo", []byte(`{}`)), ErrInvalidConfigID)
+	_, found, err = s.Get(ctx, "../"+filepath.Base(dir)+"/"+id)
+	require.NoError(t, err)
+	require.False(t, found)
+}
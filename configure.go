@@ -0,0 +1,397 @@
+package stremio
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// configureField is a form field on the generated configure page.
+// It's created from a field of the registered user data type and its struct tags:
+//   - `label:"..."`: The label of the form field. Default is the JSON key.
+//   - `help:"..."`: A help text that's shown below the form field.
+//   - `options:"a,b,c"`: Comma-separated options. Turns a string into a select and a string slice into checkboxes.
+//   - `required:"true"`: The form can't be submitted without a value.
+//   - `secret:"true"`: Turns a string into a password field.
+type configureField struct {
+	Key      string
+	Label    string
+	Help     string
+	Kind     string
+	Options  []string
+	Required bool
+	Value    string
+}
+
+// Kinds of configure fields. The JavaScript on the configure page uses them to convert the form values to the proper JSON types.
+const (
+	fieldKindText        = "text"
+	fieldKindSecret      = "secret"
+	fieldKindInt         = "int"
+	fieldKindFloat       = "float"
+	fieldKindBool        = "bool"
+	fieldKindSelect      = "select"
+	fieldKindMultiSelect = "multiselect"
+	fieldKindVersion     = "version"
+)
+
+// configureFieldsFromType creates the form fields for the configure page from the fields of the user data type.
+// The "version" field isn't shown, but set to latestVersion, so that the submitted user data doesn't get migrated.
+func configureFieldsFromType(t reflect.Type, latestVersion int) ([]configureField, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("user data type must be a struct, but is %v", t.Kind())
+	}
+
+	var fields []configureField
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if structField.PkgPath != "" {
+			// Unexported
+			continue
+		}
+		key := structField.Name
+		if jsonTag, ok := structField.Tag.Lookup("json"); ok {
+			if jsonTag == "-" {
+				continue
+			}
+			if name := strings.Split(jsonTag, ",")[0]; name != "" {
+				key = name
+			}
+		}
+
+		field := configureField{
+			Key:      key,
+			Label:    structField.Tag.Get("label"),
+			Help:     structField.Tag.Get("help"),
+			Required: structField.Tag.Get("required") == "true",
+		}
+		if field.Label == "" {
+			field.Label = key
+		}
+		if options := structField.Tag.Get("options"); options != "" {
+			field.Options = strings.Split(options, ",")
+		}
+
+		switch kind := structField.Type.Kind(); {
+		case key == userDataVersionKey && kind >= reflect.Int && kind <= reflect.Uint64:
+			field.Kind = fieldKindVersion
+			field.Value = strconv.Itoa(latestVersion)
+		case kind == reflect.String && field.Options != nil:
+			field.Kind = fieldKindSelect
+		case kind == reflect.String && structField.Tag.Get("secret") == "true":
+			field.Kind = fieldKindSecret
+		case kind == reflect.String:
+			field.Kind = fieldKindText
+		case kind == reflect.Bool:
+			field.Kind = fieldKindBool
+		case kind >= reflect.Int && kind <= reflect.Uint64:
+			field.Kind = fieldKindInt
+		case kind == reflect.Float32 || kind == reflect.Float64:
+			field.Kind = fieldKindFloat
+		case kind == reflect.Slice && structField.Type.Elem().Kind() == reflect.String && field.Options != nil:
+			field.Kind = fieldKindMultiSelect
+		default:
+			return nil, fmt.Errorf("user data field %v has unsupported type %v", structField.Name, structField.Type)
+		}
+
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// latestUserDataVersion returns the version that user data has after applying all migrations.
+func latestUserDataVersion(migrations map[int]UserDataMigration) int {
+	latest := 0
+	for version := range migrations {
+		if version+1 > latest {
+			latest = version + 1
+		}
+	}
+	return latest
+}
+
+// createConfigurePageHandler creates a handler that serves a configure page, which is generated from the fields of the user data type.
+// The page is rendered once, as it only depends on the manifest and the user data type.
+// For "/:userData/configure" requests the current user data is injected, so the form fields are populated with it.
+func createConfigurePageHandler(manifest Manifest, fields []configureField, userDataJSON userDataJSONFunc, logger *zap.Logger) fiber.Handler {
+	data := struct {
+		Manifest Manifest
+		Fields   []configureField
+	}{
+		Manifest: manifest,
+		Fields:   fields,
+	}
+	page := bytes.Buffer{}
+	if err := configurePageTemplate.Execute(&page, data); err != nil {
+		logger.Fatal("Couldn't render configure page", zap.Error(err))
+	}
+	pageBody := page.Bytes()
+
+	return func(c *fiber.Ctx) error {
+		logger.Debug("configurePageHandler called")
+
+		return sendConfigurePage(c, pageBody, userDataJSON, logger)
+	}
+}
+
+// createConfigureFSHandler creates a handler that serves the "index.html" of the ConfigureHTMLfs with the current user data injected.
+// The Fiber filesystem middleware doesn't work with parameters in the route (see https://github.com/gofiber/fiber/issues/834),
+// so it's used for "/:userData/configure" requests instead of the middleware.
+// The file is read for each request, so that it can be modified on-the-fly like with the filesystem middleware.
+func createConfigureFSHandler(fs http.FileSystem, userDataJSON userDataJSONFunc, logger *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger.Debug("configureFSHandler called")
+
+		f, err := fs.Open("/index.html")
+		if err != nil {
+			logger.Error("Couldn't open index.html of configure file system", zap.Error(err))
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		defer f.Close()
+		pageBody, err := io.ReadAll(f)
+		if err != nil {
+			logger.Error("Couldn't read index.html of configure file system", zap.Error(err))
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return sendConfigurePage(c, pageBody, userDataJSON, logger)
+	}
+}
+
+// sendConfigurePage responds with the configure page, with the current user data injected.
+func sendConfigurePage(c *fiber.Ctx, page []byte, userDataJSON userDataJSONFunc, logger *zap.Logger) error {
+	page, injected := injectCurrentUserData(c, page, userDataJSON, logger)
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	if injected {
+		// The user data can contain secrets, so the page must not be cached by proxies
+		c.Set(fiber.HeaderCacheControl, "no-store")
+	}
+	return c.Send(page)
+}
+
+// createConfigureDataHandler creates a handler that responds with the request's current user data as JSON,
+// so that configure pages can populate their fields with it.
+func createConfigureDataHandler(userDataJSON userDataJSONFunc, logger *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger.Debug("configureDataHandler called")
+
+		userData, err := userDataJSON(c)
+		if err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		// The user data can contain secrets, so it must not be cached by proxies
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Send(userData)
+	}
+}
+
+// userDataJSONFunc returns the current user data of a request as JSON.
+type userDataJSONFunc func(c *fiber.Ctx) ([]byte, error)
+
+// configureUserDataVar is the name of the global JavaScript variable that contains the current user data on configure pages.
+const configureUserDataVar = "stremioUserData"
+
+// headStartRegex matches the opening tag of the head element, but not for example of a header element.
+var headStartRegex = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// injectCurrentUserData adds a script to the page that sets the current user data as global JavaScript variable.
+// The page is returned unchanged if the request doesn't contain user data or it can't be decoded,
+// as users should still be able to create a new configuration then.
+// The boolean return value signals if the user data was injected.
+func injectCurrentUserData(c *fiber.Ctx, page []byte, userDataJSON userDataJSONFunc, logger *zap.Logger) ([]byte, bool) {
+	if c.Params("userData") == "" {
+		return page, false
+	}
+	userData, err := userDataJSON(c)
+	if err != nil {
+		logger.Warn("Couldn't get current user data for configure page, serving it without", zap.Error(err))
+		return page, false
+	}
+
+	// JSON encoding escapes "<", ">" and "&", so the user data can't end the script element early
+	script := make([]byte, 0, len(userData)+64)
+	script = append(script, "<script>window."+configureUserDataVar+" = "...)
+	script = append(script, userData...)
+	script = append(script, ";</script>\n"...)
+
+	// Right at the start of the head, before any other scripts, so they can access the user data
+	if loc := headStartRegex.FindIndex(page); loc != nil {
+		i := loc[1]
+		res := make([]byte, 0, len(page)+len(script))
+		res = append(res, page[:i]...)
+		res = append(res, script...)
+		return append(res, page[i:]...), true
+	}
+	return append(script, page...), true
+}
+
+var configurePageTemplate = template.Must(template.New("configure").Parse(`<!DOCTYPE html>
+<html lang="en">
+
+<head>
+  <meta charset="utf-8">
+  <meta name="description" content="{{.Manifest.Description}}">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+
+  <title>{{.Manifest.Name}} - Configure</title>
+
+  <style>
+    body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+    label { display: block; margin-top: 1em; font-weight: bold; }
+    label.option { font-weight: normal; margin-top: 0.2em; }
+    small { display: block; color: #666; }
+    input[type=text], input[type=password], input[type=number], select { width: 100%; box-sizing: border-box; }
+    button { margin-top: 1.5em; }
+    #error { color: #cc0000; }
+    #install { display: none; }
+  </style>
+</head>
+
+<body>
+  <main>
+    <header>
+      {{if .Manifest.Logo}}<img src="{{.Manifest.Logo}}" alt="" height="64">{{end}}
+      <h1>{{.Manifest.Name}}</h1>
+    </header>
+    <p>{{.Manifest.Description}}</p>
+    <section>
+      <h2>Configure</h2>
+      <form id="form">
+        {{- range .Fields}}
+        {{- if eq .Kind "version"}}
+        <input type="hidden" id="field-{{.Key}}" data-key="{{.Key}}" data-kind="{{.Kind}}" value="{{.Value}}">
+        {{- else if eq .Kind "bool"}}
+        <label><input type="checkbox" id="field-{{.Key}}" data-key="{{.Key}}" data-kind="{{.Kind}}"> {{.Label}}</label>
+        {{- else if eq .Kind "select"}}
+        <label for="field-{{.Key}}">{{.Label}}</label>
+        <select id="field-{{.Key}}" data-key="{{.Key}}" data-kind="{{.Kind}}"{{if .Required}} required{{end}}>
+          {{- if not .Required}}
+          <option value=""></option>
+          {{- end}}
+          {{- range .Options}}
+          <option value="{{.}}">{{.}}</option>
+          {{- end}}
+        </select>
+        {{- else if eq .Kind "multiselect"}}
+        <label>{{.Label}}</label>
+        <div data-key="{{.Key}}" data-kind="{{.Kind}}">
+          {{- range .Options}}
+          <label class="option"><input type="checkbox" value="{{.}}"> {{.}}</label>
+          {{- end}}
+        </div>
+        {{- else}}
+        <label for="field-{{.Key}}">{{.Label}}</label>
+        <input type="{{if eq .Kind "secret"}}password{{else if eq .Kind "text"}}text{{else}}number{{end}}"{{if eq .Kind "float"}} step="any"{{end}} id="field-{{.Key}}" data-key="{{.Key}}" data-kind="{{.Kind}}"{{if .Required}} required{{end}}>
+        {{- end}}
+        {{- if .Help}}
+        <small>{{.Help}}</small>
+        {{- end}}
+        {{- end}}
+        <button type="submit">Save</button>
+      </form>
+      <p id="error"></p>
+      <div id="install">
+        <h2>Install</h2>
+        <p><a id="installLink" href="#">Install in Stremio</a> or <a id="webInstallLink" href="#" target="_blank">install in Stremio Web</a></p>
+        <p>Or add this URL in Stremio's addon search:</p>
+        <input type="text" id="manifestURL" readonly>
+      </div>
+    </section>
+  </main>
+
+  <script>
+    // Both "/configure" and "/:userData/configure" serve this page, and for both the form is submitted to the same URL.
+    var configurePath = window.location.pathname.replace(/\/$/, "");
+
+    function readForm() {
+      var userData = {};
+      document.querySelectorAll("[data-key]").forEach(function (el) {
+        var key = el.dataset.key;
+        switch (el.dataset.kind) {
+          case "bool":
+            userData[key] = el.checked;
+            break;
+          case "int":
+          case "version":
+            if (el.value !== "") { userData[key] = parseInt(el.value, 10); }
+            break;
+          case "float":
+            if (el.value !== "") { userData[key] = parseFloat(el.value); }
+            break;
+          case "multiselect":
+            userData[key] = [];
+            el.querySelectorAll("input:checked").forEach(function (option) { userData[key].push(option.value); });
+            break;
+          default:
+            if (el.value !== "") { userData[key] = el.value; }
+        }
+      });
+      return userData;
+    }
+
+    // The links are created by the addon, so they always match the encoding it expects
+    function showInstall(links) {
+      document.getElementById("installLink").href = links.stremioURL;
+      document.getElementById("webInstallLink").href = links.webURL;
+      document.getElementById("manifestURL").value = links.manifestURL;
+      document.getElementById("install").style.display = "block";
+    }
+
+    function populateForm(userData) {
+      document.querySelectorAll("[data-key]").forEach(function (el) {
+        var value = userData[el.dataset.key];
+        if (value === undefined || value === null) { return; }
+        switch (el.dataset.kind) {
+          case "version":
+            // The version must stay the latest one, as the form has the latest schema
+            break;
+          case "bool":
+            el.checked = !!value;
+            break;
+          case "multiselect":
+            el.querySelectorAll("input").forEach(function (option) { option.checked = value.indexOf(option.value) !== -1; });
+            break;
+          default:
+            el.value = value;
+        }
+      });
+    }
+
+    // On "/:userData/configure" the addon injects the current user data
+    if (window.stremioUserData && typeof window.stremioUserData === "object") {
+      populateForm(window.stremioUserData);
+    }
+
+    document.getElementById("form").addEventListener("submit", function (event) {
+      event.preventDefault();
+      document.getElementById("error").textContent = "";
+      fetch(configurePath, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(readForm())
+      }).then(function (res) {
+        return res.json().catch(function () { return {}; }).then(function (body) {
+          if (!res.ok) {
+            throw new Error(body.err || "The configuration couldn't be saved (" + res.status + ")");
+          }
+          showInstall(body);
+        });
+      }).catch(function (err) {
+        document.getElementById("error").textContent = err.message;
+      });
+    });
+  </script>
+</body>
+
+</html>
+`))
@@ -0,0 +1,180 @@
+package stremio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestConfigurePages(t *testing.T) {
+	manifest := Manifest{
+		ID:            "com.example.test",
+		Name:          "Test",
+		Description:   "Test",
+		Version:       "0.1.0",
+		BehaviorHints: BehaviorHints{Configurable: true},
+	}
+	userData := `%7B%22token%22:%22secret%22%7D`
+	wantScript := `<script>window.stremioUserData = {"version":0,"token":"secret","quality":""};</script>`
+
+	configureFS := fstest.MapFS{
+		"index.html": {Data: []byte("<html>\n<head lang=\"en\">\n<script src=\"configure.js\"></script>\n</head>\n<body></body>\n</html>\n")},
+	}
+	for _, opts := range []Options{
+		{Logger: zap.NewNop(), GenerateConfigurePage: true},
+		{Logger: zap.NewNop(), ConfigureHTMLfs: http.FS(configureFS)},
+	} {
+		addon, err := NewAddon(manifest, nil, map[string]StreamHandler{}, opts)
+		require.NoError(t, err)
+		addon.RegisterUserData(testUserData{})
+		app := addon.createApp()
+
+		res, err := app.Test(httptest.NewRequest("GET", "/"+userData+"/configure", nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		// The page contains the user's secrets
+		require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		page := string(body)
+		require.Contains(t, page, wantScript)
+		// The user data must be set before any other script runs
+		require.Equal(t, strings.Index(page, "<script"), strings.Index(page, wantScript))
+
+		res, err = app.Test(httptest.NewRequest("GET", "/"+userData+"/configure/data.json", nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+		body, err = ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"version":0,"token":"secret","quality":""}`, string(body))
+
+		// Invalid user data leads to a page without it, so users can create a new configuration
+		res, err = app.Test(httptest.NewRequest("GET", "/foo/configure", nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "", res.Header.Get("Cache-Control"))
+		body, err = ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NotContains(t, string(body), "<script>window.stremioUserData")
+
+		res, err = app.Test(httptest.NewRequest("GET", "/foo/configure/data.json", nil))
+		require.NoError(t, err)
+		require.Equal(t, 400, res.StatusCode)
+	}
+}
+
+func TestConfigureFieldsFromType(t *testing.T) {
+	tests := []struct {
+		name     string
+		userData interface{}
+		expected []configureField
+		err      bool
+	}{
+		{
+			name: "kinds",
+			userData: struct {
+				Version  int
+				Token    string `secret:"true"`
+				Name     string
+				Limit    int
+				MinScore float64
+				HD       bool
+				Quality  string   `options:"720p,1080p"`
+				Langs    []string `options:"en,de"`
+			}{},
+			expected: []configureField{
+				// Only the "version" JSON key is the user data version
+				{Key: "Version", Label: "Version", Kind: fieldKindInt},
+				{Key: "Token", Label: "Token", Kind: fieldKindSecret},
+				{Key: "Name", Label: "Name", Kind: fieldKindText},
+				{Key: "Limit", Label: "Limit", Kind: fieldKindInt},
+				{Key: "MinScore", Label: "MinScore", Kind: fieldKindFloat},
+				{Key: "HD", Label: "HD", Kind: fieldKindBool},
+				{Key: "Quality", Label: "Quality", Kind: fieldKindSelect, Options: []string{"720p", "1080p"}},
+				{Key: "Langs", Label: "Langs", Kind: fieldKindMultiSelect, Options: []string{"en", "de"}},
+			},
+		},
+		{
+			name: "tags",
+			userData: struct {
+				Version int    `json:"version"`
+				Token   string `json:"token,omitempty" label:"API token" help:"See your account page" required:"true" secret:"true"`
+				Ignored string `json:"-"`
+				private string
+			}{},
+			expected: []configureField{
+				{Key: "version", Label: "version", Kind: fieldKindVersion, Value: "3"},
+				{Key: "token", Label: "API token", Help: "See your account page", Kind: fieldKindSecret, Required: true},
+			},
+		},
+		{
+			name:     "unsupported field type",
+			userData: struct{ Extra map[string]string }{},
+			err:      true,
+		},
+		{
+			name:     "slice without options",
+			userData: struct{ Langs []string }{},
+			err:      true,
+		},
+		{
+			name:     "no struct",
+			userData: "foo",
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := configureFieldsFromType(reflect.TypeOf(test.userData), 3)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, fields)
+		})
+	}
+}
+
+func TestConfigurePageFields(t *testing.T) {
+	fields, err := configureFieldsFromType(reflect.TypeOf(struct {
+		Version int      `json:"version"`
+		Token   string   `json:"token" label:"API token" help:"See your account page" required:"true" secret:"true"`
+		Quality string   `json:"quality" options:"720p,1080p"`
+		Langs   []string `json:"langs" options:"en,de"`
+		HD      bool     `json:"hd"`
+	}{}), 2)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/configure", createConfigurePageHandler(Manifest{Name: "Test"}, fields, nil, zap.NewNop()))
+	res, err := app.Test(httptest.NewRequest("GET", "/configure", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	page := string(body)
+
+	for _, want := range []string{
+		`<input type="hidden" id="field-version" data-key="version" data-kind="version" value="2">`,
+		`<label for="field-token">API token</label>`,
+		`<input type="password" id="field-token" data-key="token" data-kind="secret" required>`,
+		`<small>See your account page</small>`,
+		`<select id="field-quality" data-key="quality" data-kind="select">`,
+		`<option value="1080p">1080p</option>`,
+		`<div data-key="langs" data-kind="multiselect">`,
+		`<label class="option"><input type="checkbox" value="de"> de</label>`,
+		`<label><input type="checkbox" id="field-hd" data-key="hd" data-kind="bool"> hd</label>`,
+	} {
+		require.Contains(t, page, want)
+	}
+}
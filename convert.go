@@ -0,0 +1,168 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"go.uber.org/zap"
+)
+
+// enrichConcurrency is the maximum number of concurrent MetaFetcher requests when enriching a catalog.
+const enrichConcurrency = 10
+
+// MetaItemFromCinemeta converts a Cinemeta meta object to a MetaItem, for example for responding to meta requests.
+// The episodes of TV shows are converted to VideoItems.
+func MetaItemFromCinemeta(meta cinemeta.Meta) MetaItem {
+	var videos []VideoItem
+	if meta.Videos != nil {
+		videos = make([]VideoItem, len(meta.Videos))
+		for i, video := range meta.Videos {
+			videos[i] = VideoItem{
+				ID:        video.ID,
+				Title:     video.Name,
+				Released:  video.Released,
+				Thumbnail: video.Thumbnail,
+				Season:    strconv.Itoa(video.Season),
+				Episode:   strconv.Itoa(video.Episode),
+				Overview:  video.Overview,
+			}
+		}
+	}
+
+	return MetaItem{
+		ID:   meta.ID,
+		Type: meta.Type,
+		Name: meta.Name,
+
+		Genres:      copyStrings(meta.Genres),
+		Director:    copyStrings(meta.Director),
+		Cast:        copyStrings(meta.Cast),
+		Poster:      meta.Poster,
+		PosterShape: meta.PosterShape,
+		Background:  meta.Background,
+		Logo:        meta.Logo,
+		Description: meta.Description,
+		ReleaseInfo: meta.ReleaseInfo,
+		IMDbRating:  meta.IMDbRating,
+		Released:    meta.Released,
+		Videos:      videos,
+		Runtime:     meta.Runtime,
+		Language:    meta.Language,
+		Country:     meta.Country,
+		Awards:      meta.Awards,
+		Website:     meta.Website,
+	}
+}
+
+// MetaPreviewItemFromCinemeta converts a Cinemeta meta object to a MetaPreviewItem, for example for catalog responses.
+func MetaPreviewItemFromCinemeta(meta cinemeta.Meta) MetaPreviewItem {
+	return MetaPreviewItem{
+		ID:     meta.ID,
+		Type:   meta.Type,
+		Name:   meta.Name,
+		Poster: meta.Poster,
+
+		PosterShape: meta.PosterShape,
+
+		Genres:      copyStrings(meta.Genres),
+		Director:    copyStrings(meta.Director),
+		Cast:        copyStrings(meta.Cast),
+		IMDbRating:  meta.IMDbRating,
+		ReleaseInfo: meta.ReleaseInfo,
+		Description: meta.Description,
+	}
+}
+
+// MetaPreviewItemFromCinemetaPreview converts an item of a Cinemeta catalog or search result to a MetaPreviewItem.
+func MetaPreviewItemFromCinemetaPreview(metaPreview cinemeta.MetaPreview) MetaPreviewItem {
+	return MetaPreviewItem{
+		ID:     metaPreview.ID,
+		Type:   metaPreview.Type,
+		Name:   metaPreview.Name,
+		Poster: metaPreview.Poster,
+
+		PosterShape: metaPreview.PosterShape,
+
+		Genres:      copyStrings(metaPreview.Genres),
+		IMDbRating:  metaPreview.IMDbRating,
+		ReleaseInfo: metaPreview.ReleaseInfo,
+		Description: metaPreview.Description,
+	}
+}
+
+// copyStrings copies the slice, so that modifying the converted items can't modify cached meta objects.
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// enrichMetaPreviewItems returns a copy of the items, with the empty fields of the items that don't have a name filled with the meta from the MetaFetcher.
+// The items themselves aren't modified, because handlers can return slices they keep, like a catalog in memory.
+// Failing to get the meta of an item leaves the item as it is.
+func enrichMetaPreviewItems(ctx context.Context, items []MetaPreviewItem, metaClient MetaFetcher, logger *zap.Logger) []MetaPreviewItem {
+	items = append(make([]MetaPreviewItem, 0, len(items)), items...)
+	sem := make(chan struct{}, enrichConcurrency)
+	var wg sync.WaitGroup
+	for i := range items {
+		if items[i].Name != "" {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(item *MetaPreviewItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			var meta cinemeta.Meta
+			var err error
+			switch item.Type {
+			case "movie":
+				meta, err = metaClient.GetMovie(ctx, item.ID)
+			case "series":
+				meta, err = metaClient.GetTVShow(ctx, item.ID, 0, 0)
+			default:
+				logger.Debug("Can't enrich catalog item of unsupported type", zap.String("type", item.Type), zap.String("id", item.ID))
+				return
+			}
+			if errors.Is(err, ErrUnsupportedIDScheme) {
+				logger.Debug("No MetaFetcher for ID scheme of catalog item", zap.String("id", item.ID))
+				return
+			} else if err != nil {
+				logger.Warn("Couldn't get meta for enriching catalog item", zap.Error(err), zap.String("id", item.ID))
+				return
+			}
+			enrichMetaPreviewItem(item, meta)
+		}(&items[i])
+	}
+	wg.Wait()
+	return items
+}
+
+// enrichMetaPreviewItem fills the empty fields of the item with the meta.
+func enrichMetaPreviewItem(item *MetaPreviewItem, meta cinemeta.Meta) {
+	item.Name = meta.Name
+	if item.Poster == "" {
+		item.Poster = meta.Poster
+	}
+	if item.PosterShape == "" {
+		item.PosterShape = meta.PosterShape
+	}
+	if item.Genres == nil {
+		item.Genres = copyStrings(meta.Genres)
+	}
+	if item.IMDbRating == "" {
+		item.IMDbRating = meta.IMDbRating
+	}
+	if item.ReleaseInfo == "" {
+		item.ReleaseInfo = meta.ReleaseInfo
+	}
+	if item.Description == "" {
+		item.Description = meta.Description
+	}
+}
@@ -0,0 +1,43 @@
+package stremio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMetaItemFromCinemeta(t *testing.T) {
+	meta := cinemeta.Meta{
+		ID:     "tt0944947",
+		Type:   "series",
+		Name:   "Game of Thrones",
+		Genres: []string{"Drama"},
+		Videos: []cinemeta.Video{{ID: "tt0944947:1:1", Name: "Winter Is Coming", Season: 1, Episode: 1}},
+	}
+	metaItem := MetaItemFromCinemeta(meta)
+	require.Equal(t, "Game of Thrones", metaItem.Name)
+	require.Equal(t, []VideoItem{{ID: "tt0944947:1:1", Title: "Winter Is Coming", Season: "1", Episode: "1"}}, metaItem.Videos)
+
+	// Modifying the converted item must not modify the original
+	metaItem.Genres[0] = "Fantasy"
+	require.Equal(t, "Drama", meta.Genres[0])
+}
+
+func TestEnrichMetaPreviewItems(t *testing.T) {
+	items := []MetaPreviewItem{
+		{ID: "tt0133093", Type: "movie"},
+		{ID: "tt0944947", Type: "series", Name: "Own name"},
+		{ID: "kitsu:123", Type: "series"},
+	}
+	metaClient := NewMetaRouter(map[string]MetaFetcher{IDSchemeIMDb: staticMetaFetcher{name: "Name"}}, nil)
+
+	enriched := enrichMetaPreviewItems(context.Background(), items, metaClient, zap.NewNop())
+	require.Equal(t, "Name", enriched[0].Name)
+	require.Equal(t, "Own name", enriched[1].Name)
+	require.Equal(t, "", enriched[2].Name)
+	// The handler's items must not be modified
+	require.Equal(t, "", items[0].Name)
+}
@@ -1,14 +1,68 @@
 package stremio
 
 import (
-	"errors"
+	"fmt"
+	"net/http"
+	"time"
 )
 
 var (
 	// BadRequest signals that the client sent a bad request.
 	// It leads to a "400 Bad Request" response.
-	BadRequest = errors.New("Bad request")
+	BadRequest error = &Error{Status: http.StatusBadRequest, Message: "Bad request"}
 	// NotFound signals that the catalog/meta/stream was not found.
 	// It leads to a "404 Not Found" response.
-	NotFound = errors.New("Not found")
+	NotFound error = &Error{Status: http.StatusNotFound, Message: "Not found"}
 )
+
+// Error is an error that handlers can return to control the HTTP response.
+// The response has the status code and the JSON body `{"err": "<message>"}`.
+// It can be wrapped, for example with `fmt.Errorf("...: %w", err)`, and is still detected.
+// `errors.Is()` matches any two Errors with the same status code, so `errors.Is(err, NotFound)` is true for all 404 Errors.
+type Error struct {
+	// HTTP status code of the response, e.g. 503.
+	// Status codes below 400 aren't errors, so they lead to a "500 Internal Server Error" response.
+	Status int
+	// Message for the client. If empty, the status text is used, e.g. "Service Unavailable".
+	Message string
+	// Duration after which the client can retry the request, sent in the "Retry-After" header.
+	// It's rounded up to full seconds. 0 means no header.
+	RetryAfter time.Duration
+	// Cause of the error, for logging. It's not sent to the client.
+	Err error
+}
+
+// NewError creates a new Error with the given HTTP status code and message for the client.
+func NewError(status int, message string) *Error {
+	return &Error{
+		Status:  status,
+		Message: message,
+	}
+}
+
+// Error returns the status code, message and cause.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v %v: %v", e.Status, e.message(), e.Err)
+	}
+	return fmt.Sprintf("%v %v", e.Status, e.message())
+}
+
+// Unwrap returns the cause of the error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if the target is an Error with the same status code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Status == e.Status
+}
+
+// message returns the message for the client.
+func (e *Error) message() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.Status)
+}
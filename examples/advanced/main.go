@@ -217,7 +217,7 @@ func createAuthMiddleware(addon *stremio.Addon, logger *zap.Logger) fiber.Handle
 // Showcases the usage of meta info in the context.
 func createMetaMiddleware(logger *zap.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		if meta, err := cinemeta.GetMetaFromContext(c.Context()); err != nil {
+		if meta, err := stremio.GetMetaFromFiberCtx(c); err != nil {
 			if err == cinemeta.ErrNoMeta {
 				logger.Warn("Meta not found in context")
 			} else {
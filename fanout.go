@@ -0,0 +1,165 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// StreamProvider is a named source of streams, like a single website or API.
+type StreamProvider struct {
+	// Name of the provider, used in logs and as "provider" label in metrics.
+	Name string
+	// GetStreams returns the provider's streams for the movie or TV show episode.
+	// The context is canceled when the provider's or the overall deadline is exceeded.
+	GetStreams func(ctx context.Context, t, id string, userData interface{}) ([]StreamItem, error)
+}
+
+// StreamFanOut queries multiple StreamProviders concurrently and merges their results,
+// which is what stream handlers of addons with multiple sources usually do.
+// Providers that fail or exceed their deadline are skipped, so the result can be partial.
+// Duplicate streams (same URL, or same InfoHash and file index) are removed, keeping the stream of the first provider.
+// For each provider it records the metrics "stream_provider_duration_seconds" (histogram)
+// and "stream_provider_requests_total" with the "result" label being "success", "error" or "timeout".
+type StreamFanOut struct {
+	providers       []StreamProvider
+	providerTimeout time.Duration
+	timeout         time.Duration
+	logger          *zap.Logger
+}
+
+// NewStreamFanOut creates a new StreamFanOut.
+// The order of the providers is the order of their streams in the result.
+// The providerTimeout is the deadline for each provider, the timeout the deadline for all of them together.
+// A timeout of 0 means that only the request context's deadline applies.
+func NewStreamFanOut(providers []StreamProvider, providerTimeout, timeout time.Duration, logger *zap.Logger) *StreamFanOut {
+	return &StreamFanOut{
+		providers:       providers,
+		providerTimeout: providerTimeout,
+		timeout:         timeout,
+		logger:          logger,
+	}
+}
+
+type providerResult struct {
+	streams []StreamItem
+	err     error
+}
+
+// GetStreams queries all providers concurrently and returns their merged and deduplicated streams.
+// It returns an error only if all providers failed.
+func (f *StreamFanOut) GetStreams(ctx context.Context, t, id string, userData interface{}) ([]StreamItem, error) {
+	if len(f.providers) == 0 {
+		return nil, errors.New("No stream providers")
+	}
+	if f.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	results := make([]providerResult, len(f.providers))
+	var wg sync.WaitGroup
+	wg.Add(len(f.providers))
+	for i, provider := range f.providers {
+		go func(i int, provider StreamProvider) {
+			defer wg.Done()
+			results[i] = f.query(ctx, provider, t, id, userData)
+		}(i, provider)
+	}
+	// Each query returns at its deadline at the latest, even if the provider ignores the context
+	wg.Wait()
+
+	var streams []StreamItem
+	var err error
+	failed := 0
+	seen := map[string]struct{}{}
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			err = result.err
+			continue
+		}
+		for _, stream := range result.streams {
+			key := streamKey(stream)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			streams = append(streams, stream)
+		}
+	}
+	if failed == len(results) {
+		return nil, fmt.Errorf("All %v stream providers failed, the last one with: %w", failed, err)
+	}
+	return streams, nil
+}
+
+// StreamHandler returns a StreamHandler for the given type that uses the StreamFanOut.
+func (f *StreamFanOut) StreamHandler(t string) StreamHandler {
+	return func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return f.GetStreams(ctx, t, id, userData)
+	}
+}
+
+// query calls the provider and returns its result, or a timeout error when the provider's deadline is exceeded.
+func (f *StreamFanOut) query(ctx context.Context, provider StreamProvider, t, id string, userData interface{}) providerResult {
+	if f.providerTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.providerTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	// Buffered, so the goroutine can finish even when we don't wait for it anymore
+	resultChan := make(chan providerResult, 1)
+	go func() {
+		streams, err := provider.GetStreams(ctx, t, id, userData)
+		resultChan <- providerResult{streams: streams, err: err}
+	}()
+	var result providerResult
+	select {
+	case result = <-resultChan:
+	case <-ctx.Done():
+		result = providerResult{err: ctx.Err()}
+	}
+	duration := time.Since(start)
+
+	var resultLabel string
+	zapFieldProvider, zapFieldDuration := zap.String("provider", provider.Name), zap.Duration("duration", duration)
+	switch {
+	case result.err == nil:
+		resultLabel = "success"
+	case errors.Is(result.err, context.DeadlineExceeded):
+		resultLabel = "timeout"
+		f.logger.Warn("Stream provider exceeded its deadline", zapFieldProvider, zapFieldDuration)
+	default:
+		resultLabel = "error"
+		f.logger.Warn("Stream provider failed", zap.Error(result.err), zapFieldProvider, zapFieldDuration)
+	}
+	metrics.GetOrCreateHistogram(fmt.Sprintf(`stream_provider_duration_seconds{provider=%q}`, provider.Name)).Update(duration.Seconds())
+	metrics.GetOrCreateCounter(fmt.Sprintf(`stream_provider_requests_total{provider=%q, result="%v"}`, provider.Name, resultLabel)).Inc()
+
+	return result
+}
+
+// streamKey returns the key for deduplicating streams.
+func streamKey(stream StreamItem) string {
+	switch {
+	case stream.InfoHash != "":
+		return "infoHash:" + strings.ToLower(stream.InfoHash) + ":" + strconv.Itoa(int(stream.FileIndex))
+	case stream.URL != "":
+		return "url:" + stream.URL
+	case stream.YoutubeID != "":
+		return "ytId:" + stream.YoutubeID
+	default:
+		return "externalUrl:" + stream.ExternalURL
+	}
+}
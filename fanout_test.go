@@ -0,0 +1,41 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStreamFanOut(t *testing.T) {
+	staticProvider := func(name string, streams []StreamItem, err error, delay time.Duration) StreamProvider {
+		return StreamProvider{
+			Name: name,
+			GetStreams: func(_ context.Context, _, _ string, _ interface{}) ([]StreamItem, error) {
+				// Ignores the context on purpose, the fan-out must not wait anyway
+				time.Sleep(delay)
+				return streams, err
+			},
+		}
+	}
+	f := NewStreamFanOut([]StreamProvider{
+		staticProvider("a", []StreamItem{{URL: "http://example.com/1"}, {InfoHash: "ABC"}}, nil, 0),
+		staticProvider("b", []StreamItem{{URL: "http://example.com/1"}, {InfoHash: "abc"}, {InfoHash: "abc", FileIndex: 1}}, nil, 0),
+		staticProvider("c", nil, errors.New("foo"), 0),
+		staticProvider("slow", []StreamItem{{URL: "http://example.com/slow"}}, nil, time.Second),
+	}, 50*time.Millisecond, time.Second, zap.NewNop())
+
+	start := time.Now()
+	streams, err := f.GetStreams(context.Background(), "movie", "tt1", nil)
+	require.NoError(t, err)
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	require.Equal(t, []StreamItem{{URL: "http://example.com/1"}, {InfoHash: "ABC"}, {InfoHash: "abc", FileIndex: 1}}, streams)
+
+	// An error is only returned when all providers fail
+	f = NewStreamFanOut([]StreamProvider{staticProvider("c", nil, NotFound, 0)}, 0, 0, zap.NewNop())
+	_, err = f.GetStreams(context.Background(), "movie", "tt1", nil)
+	require.ErrorIs(t, err, NotFound)
+}
@@ -1,10 +1,14 @@
 package stremio
 
 import (
+	"bytes"
+	"compress/flate"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/url"
 	"reflect"
@@ -13,6 +17,7 @@ import (
 	"time"
 
 	"github.com/cespare/xxhash/v2"
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
 )
@@ -30,7 +35,7 @@ func createHealthHandler(logger *zap.Logger) fiber.Handler {
 	}
 }
 
-func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallback ManifestCallback, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallback ManifestCallback, userDataType reflect.Type, userDataIsBase64 bool, configStore ConfigStore, migrations map[int]UserDataMigration) fiber.Handler {
 	// When there's user data we want Stremio to show the "Install" button, which it only does when "configurationRequired" is false.
 	// To not change the boolean value of the manifest object on the fly and thus mess with a single object across concurrent goroutines, we copy it and return two different objects.
 	// Note that this manifest copy has some values shallowly copied, but `BehaviorHints.ConfigurationRequired` is a simple type and thus a real copy.
@@ -62,11 +67,18 @@ func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallba
 		} else {
 			configured = true
 			if userDataType == nil {
+				if configStore != nil {
+					config, err := resolveConfigID(c.Context(), userDataString, configStore, logger)
+					if err != nil {
+						return c.SendStatus(userDataError(err).Status)
+					}
+					userDataString = string(config)
+				}
 				userData = userDataString
 			} else {
 				var err error
-				if userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64); err != nil {
-					return c.SendStatus(fiber.StatusBadRequest)
+				if userData, err = decodeUserData(c.Context(), userDataString, userDataType, logger, userDataIsBase64, configStore, migrations); err != nil {
+					return c.SendStatus(userDataError(err).Status)
 				}
 			}
 		}
@@ -101,53 +113,164 @@ func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallba
 	}
 }
 
-func createCatalogHandler(catalogHandlers map[string]CatalogHandler, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+// handlerOptions are the settings of the catalog, stream, meta and subtitles handlers.
+type handlerOptions struct {
+	cacheAge         time.Duration
+	cachePublic      bool
+	handleEtag       bool
+	logger           *zap.Logger
+	userDataType     reflect.Type
+	userDataIsBase64 bool
+	configStore      ConfigStore
+	migrations       map[int]UserDataMigration
+	// If true, NotFound errors lead to an empty "200 OK" response that can be cached for notFoundCacheAge.
+	notFoundAsEmpty  bool
+	notFoundCacheAge time.Duration
+	// Notices are rendered as results and can be cached privately for noticeCacheAge.
+	defaultNoticeURL string
+	noticeCacheAge   time.Duration
+	hasConfigurePage bool
+	beforeHooks      []BeforeHook
+	// If true, the meta middleware fetches the meta before the handler is called, so it can be put into the handler's context.
+	putMetaInContext bool
+}
+
+// createCatalogHandler creates the handler for catalog requests.
+// If enrichMetaClient is not nil, items without a name are filled with meta from it.
+func createCatalogHandler(catalogHandlers map[string]CatalogHandler, opts handlerOptions, enrichMetaClient MetaFetcher, afterHooks []CatalogAfterHook) fiber.Handler {
 	handlers := make(map[string]handler, len(catalogHandlers))
 	for k, v := range catalogHandlers {
-		handlers[k] = convertCatalogHandler(v)
+		handlers[k] = convertCatalogHandler(k, v, enrichMetaClient, opts.beforeHooks, afterHooks, opts.logger)
 	}
-	return createHandler("catalog", handlers, []byte("metas"), cacheAge, cachePublic, handleEtag, logger, userDataType, userDataIsBase64)
+	return createHandler("catalog", handlers, []byte("metas"), opts)
 }
 
-func createStreamHandler(streamHandlers map[string]StreamHandler, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+func createStreamHandler(streamHandlers map[string]StreamHandler, opts handlerOptions, afterHooks []StreamAfterHook) fiber.Handler {
 	handlers := make(map[string]handler, len(streamHandlers))
 	for k, v := range streamHandlers {
-		handlers[k] = convertStreamHandler(v)
+		handlers[k] = convertStreamHandler(k, v, opts.beforeHooks, afterHooks)
+	}
+	return createHandler("stream", handlers, []byte("streams"), opts)
+}
+
+func createMetaHandler(metaHandlers map[string]MetaHandler, opts handlerOptions, afterHooks []MetaAfterHook) fiber.Handler {
+	handlers := make(map[string]handler, len(metaHandlers))
+	for k, v := range metaHandlers {
+		handlers[k] = convertMetaHandler(k, v, opts.beforeHooks, afterHooks)
 	}
-	return createHandler("stream", handlers, []byte("streams"), cacheAge, cachePublic, handleEtag, logger, userDataType, userDataIsBase64)
+	return createHandler("meta", handlers, []byte("meta"), opts)
 }
 
-func convertCatalogHandler(h CatalogHandler) handler {
+func createSubtitlesHandler(subtitlesHandlers map[string]SubtitlesHandler, opts handlerOptions) fiber.Handler {
+	handlers := make(map[string]handler, len(subtitlesHandlers))
+	for k, v := range subtitlesHandlers {
+		handlers[k] = convertSubtitlesHandler(k, v, opts.beforeHooks)
+	}
+	return createHandler("subtitles", handlers, []byte("subtitles"), opts)
+}
+
+func convertCatalogHandler(t string, h CatalogHandler, enrichMetaClient MetaFetcher, beforeHooks []BeforeHook, afterHooks []CatalogAfterHook, logger *zap.Logger) handler {
 	return func(ctx context.Context, id string, userData interface{}) (interface{}, error) {
-		return h(ctx, id, userData)
+		id, userData, err := runBeforeHooks(ctx, beforeHooks, "catalog", t, id, userData)
+		if err != nil {
+			return nil, err
+		}
+		items, err := h(ctx, id, userData)
+		if err != nil {
+			return nil, err
+		}
+		if enrichMetaClient != nil {
+			items = enrichMetaPreviewItems(ctx, items, enrichMetaClient, logger)
+		}
+		for _, hook := range afterHooks {
+			if items, err = hook(ctx, t, id, userData, items); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+}
+
+func convertStreamHandler(t string, h StreamHandler, beforeHooks []BeforeHook, afterHooks []StreamAfterHook) handler {
+	return func(ctx context.Context, id string, userData interface{}) (interface{}, error) {
+		id, userData, err := runBeforeHooks(ctx, beforeHooks, "stream", t, id, userData)
+		if err != nil {
+			return nil, err
+		}
+		streams, err := h(ctx, id, userData)
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range afterHooks {
+			if streams, err = hook(ctx, t, id, userData, streams); err != nil {
+				return nil, err
+			}
+		}
+		return streams, nil
+	}
+}
+
+func convertMetaHandler(t string, h MetaHandler, beforeHooks []BeforeHook, afterHooks []MetaAfterHook) handler {
+	return func(ctx context.Context, id string, userData interface{}) (interface{}, error) {
+		id, userData, err := runBeforeHooks(ctx, beforeHooks, "meta", t, id, userData)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := h(ctx, id, userData)
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range afterHooks {
+			if meta, err = hook(ctx, t, id, userData, meta); err != nil {
+				return nil, err
+			}
+		}
+		return meta, nil
 	}
 }
 
-func convertStreamHandler(h StreamHandler) handler {
+func convertSubtitlesHandler(t string, h SubtitlesHandler, beforeHooks []BeforeHook) handler {
 	return func(ctx context.Context, id string, userData interface{}) (interface{}, error) {
+		id, userData, err := runBeforeHooks(ctx, beforeHooks, "subtitles", t, id, userData)
+		if err != nil {
+			return nil, err
+		}
 		return h(ctx, id, userData)
 	}
 }
 
-// Common handler (same signature as both catalog and stream handler)
+// Common handler (same signature as all resource handlers)
 type handler func(ctx context.Context, id string, userData interface{}) (interface{}, error)
 
-func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey []byte, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+// cacheControlValue returns the value for the "Cache-Control" header, or an empty string if the cache age is 0.
+func cacheControlValue(cacheAge time.Duration, cachePublic bool) string {
+	if cacheAge == 0 {
+		return ""
+	}
+	cacheAgeSeconds := strconv.FormatFloat(math.Round(cacheAge.Seconds()), 'f', 0, 64)
+	if cachePublic {
+		return "max-age=" + cacheAgeSeconds + ", public"
+	}
+	return "max-age=" + cacheAgeSeconds + ", private"
+}
+
+// createHandler creates the common handler for catalog, stream, meta and subtitles requests.
+// The result is wrapped in a JSON object with jsonKey as key, like `{"metas":[...]}`.
+func createHandler(handlerName string, handlers map[string]handler, jsonKey []byte, opts handlerOptions) fiber.Handler {
 	handlerName = handlerName + "Handler"
 	handlerLogMsg := handlerName + " called"
 
-	var cacheHeaderVal string
-	if cacheAge != 0 {
-		cacheAgeSeconds := strconv.FormatFloat(math.Round(cacheAge.Seconds()), 'f', 0, 64)
-		cacheHeaderVal = "max-age=" + cacheAgeSeconds
-		if cachePublic {
-			cacheHeaderVal += ", public"
-		} else {
-			cacheHeaderVal += ", private"
-		}
+	cacheHeaderVal := cacheControlValue(opts.cacheAge, opts.cachePublic)
+	notFoundCacheHeaderVal := cacheControlValue(opts.notFoundCacheAge, opts.cachePublic)
+	// Notices are usually specific to the user
+	noticeCacheHeaderVal := cacheControlValue(opts.noticeCacheAge, false)
+	// Meta responses contain a single object, the others a list
+	emptyResBody := []byte(`{"` + string(jsonKey) + `":[]}`)
+	if string(jsonKey) == "meta" {
+		emptyResBody = []byte(`{"meta":null}`)
 	}
 
-	logger = logger.With(zap.String("handler", handlerName))
+	logger := opts.logger.With(zap.String("handler", handlerName))
 
 	return func(c *fiber.Ctx) error {
 		logger.Debug(handlerLogMsg)
@@ -156,8 +279,8 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 		requestedID := c.Params("id")
 		requestedID, err := url.PathUnescape(requestedID)
 		if err != nil {
-			logger.Error("Requested ID couldn't be unescaped", zap.String("requestedID", requestedID))
-			return c.SendStatus(fiber.StatusBadRequest)
+			logger.Warn("Requested ID couldn't be unescaped", zap.String("requestedID", requestedID))
+			return sendError(c, NewError(fiber.StatusBadRequest, "Invalid ID"))
 		}
 
 		zapLogType, zapLogID := zap.String("requestedType", requestedType), zap.String("requestedID", requestedID)
@@ -165,48 +288,103 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 		// Check if we have a handler for the type
 		handler, ok := handlers[requestedType]
 		if !ok {
-			logger.Warn("Got request for unhandled type; returning 404")
-			return c.SendStatus(fiber.StatusNotFound)
+			logger.Warn("Got request for unhandled type; returning 404", zapLogType, zapLogID)
+			return sendError(c, NewError(fiber.StatusNotFound, "Unhandled type"))
 		}
 
 		// Decode user data
 		var userData interface{}
 		userDataString := c.Params("userData")
-		if userDataType == nil {
+		// The user data as in the URL, because with a ConfigStore the user data string can be replaced by the stored config
+		userDataParam := userDataString
+		if opts.userDataType == nil {
+			if opts.configStore != nil && userDataString != "" {
+				config, err := resolveConfigID(c.Context(), userDataString, opts.configStore, logger)
+				if err != nil {
+					return sendError(c, userDataError(err))
+				}
+				userDataString = string(config)
+			}
 			userData = userDataString
 		} else if userDataString == "" {
 			userData = nil
 		} else {
 			var err error
-			if userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64); err != nil {
-				return c.SendStatus(fiber.StatusBadRequest)
+			if userData, err = decodeUserData(c.Context(), userDataString, opts.userDataType, logger, opts.userDataIsBase64, opts.configStore, opts.migrations); err != nil {
+				return sendError(c, userDataError(err))
 			}
 		}
 
-		res, err := handler(c.Context(), requestedID, userData)
-		if err != nil {
-			switch err {
-			case NotFound:
-				logger.Warn("Got request for unhandled media ID; returning 404")
-				return c.SendStatus(fiber.StatusNotFound)
-			case BadRequest:
-				logger.Warn("Got bad request; returning 400")
-				return c.SendStatus(fiber.StatusBadRequest)
-			default:
+		// Fiber's context only supports string keys, so the meta is passed via a context with go-stremio's typed key
+		// Without PutMetaInContext the meta middleware fetches the meta concurrently, so we must not read it here.
+		var ctx context.Context = c.Context()
+		if opts.putMetaInContext {
+			if meta, err := GetMetaFromFiberCtx(c); err == nil {
+				ctx = cinemeta.NewContextWithMeta(ctx, meta)
+			}
+		}
+		// Catalog and subtitles requests can have extras like "genre=Action&skip=100"
+		if extraString := c.Params("extra"); extraString != "" {
+			extra, err := parseCatalogExtra(extraString)
+			if err != nil {
+				logger.Warn("Couldn't parse extra", zap.Error(err), zapLogType, zapLogID)
+				return sendError(c, NewError(fiber.StatusBadRequest, "Invalid extra"))
+			}
+			ctx = context.WithValue(ctx, catalogExtraContextKey{}, extra)
+		}
+		res, err := handler(ctx, requestedID, userData)
+		var notice *Notice
+		if err != nil && errors.As(err, &notice) {
+			link := noticeURL(notice, opts.defaultNoticeURL, c.BaseURL(), userDataParam, opts.hasConfigurePage)
+			resBody, err := renderNotice(notice, jsonKey, requestedType, requestedID, link)
+			if err != nil {
+				logger.Error("Couldn't marshal notice", zap.Error(err), zapLogType, zapLogID)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"err": "Internal server error"})
+			}
+			logger.Debug("Addon returned notice; responding with it", zap.String("notice", notice.Error()), zapLogType, zapLogID)
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			if noticeCacheHeaderVal != "" {
+				c.Set(fiber.HeaderCacheControl, noticeCacheHeaderVal)
+			}
+			return c.Send(resBody)
+		} else if err != nil && opts.notFoundAsEmpty && errors.Is(err, NotFound) {
+			logger.Debug("Got request for unhandled media ID; returning empty response", zap.Error(err), zapLogType, zapLogID)
+			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			if notFoundCacheHeaderVal != "" {
+				c.Set(fiber.HeaderCacheControl, notFoundCacheHeaderVal)
+			}
+			return c.Send(emptyResBody)
+		} else if err != nil {
+			var addonErr *Error
+			if !errors.As(err, &addonErr) {
 				logger.Error("Addon returned error", zap.Error(err), zapLogType, zapLogID)
-				return c.SendStatus(fiber.StatusInternalServerError)
+				// The error could contain internal info, so we don't send it to the client
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"err": "Internal server error"})
+			}
+			switch {
+			case addonErr.Status < 400:
+				// Not an error status, so it's a bug in the addon that we must not turn into a successful response
+				logger.Error("Addon returned error with non-error status; returning 500", zap.Error(err), zap.Int("status", addonErr.Status), zapLogType, zapLogID)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"err": "Internal server error"})
+			case addonErr.Status == fiber.StatusNotFound:
+				logger.Warn("Got request for unhandled media ID; returning 404", zap.Error(err), zapLogType, zapLogID)
+			case addonErr.Status < 500:
+				logger.Warn("Addon returned client error", zap.Error(err), zap.Int("status", addonErr.Status), zapLogType, zapLogID)
+			default:
+				logger.Error("Addon returned error", zap.Error(err), zap.Int("status", addonErr.Status), zapLogType, zapLogID)
 			}
+			return sendError(c, addonErr)
 		}
 
 		resBody, err := json.Marshal(res)
 		if err != nil {
 			logger.Error("Couldn't marshal response", zap.Error(err), zapLogType, zapLogID)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"err": "Internal server error"})
 		}
 
 		// Handle ETag
 		var eTag string
-		if handleEtag {
+		if opts.handleEtag {
 			hash := xxhash.Sum64(resBody)
 			eTag = strconv.FormatUint(hash, 16)
 			ifNoneMatch := c.Get("If-None-Match")
@@ -227,8 +405,8 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 			}
 		}
 
-		if len(jsonArrayKey) > 0 {
-			prefix := append([]byte(`{"`), jsonArrayKey...)
+		if len(jsonKey) > 0 {
+			prefix := append([]byte(`{"`), jsonKey...)
 			prefix = append(prefix, '"', ':')
 			resBody = append(prefix, resBody...)
 			resBody = append(resBody, '}')
@@ -238,7 +416,7 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 		if cacheHeaderVal != "" {
 			c.Set(fiber.HeaderCacheControl, cacheHeaderVal)
-			if handleEtag {
+			if opts.handleEtag {
 				c.Set(fiber.HeaderETag, eTag)
 			}
 		}
@@ -246,6 +424,24 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 	}
 }
 
+// sendError responds with the error's status code and the JSON body `{"err": "<message>"}`.
+func sendError(c *fiber.Ctx, addonErr *Error) error {
+	if addonErr.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(addonErr.RetryAfter.Seconds()))))
+	}
+	return c.Status(addonErr.Status).JSON(fiber.Map{"err": addonErr.message()})
+}
+
+// userDataError returns the Error for a failed user data lookup or decoding.
+// Config store failures are already Errors with a server error status, all other errors are caused by the client.
+func userDataError(err error) *Error {
+	var addonErr *Error
+	if errors.As(err, &addonErr) {
+		return addonErr
+	}
+	return NewError(fiber.StatusBadRequest, "Invalid user data")
+}
+
 func createRootHandler(redirectURL string, logger *zap.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		logger.Debug("rootHandler called")
@@ -256,12 +452,20 @@ func createRootHandler(redirectURL string, logger *zap.Logger) fiber.Handler {
 	}
 }
 
-func decodeUserData(data string, t reflect.Type, logger *zap.Logger, userDataIsBase64 bool) (interface{}, error) {
+func decodeUserData(ctx context.Context, data string, t reflect.Type, logger *zap.Logger, userDataIsBase64 bool, configStore ConfigStore, migrations map[int]UserDataMigration) (interface{}, error) {
 	logger.Debug("Decoding user data", zap.String("userData", data))
 
 	var userDataDecoded []byte
 	var err error
-	if userDataIsBase64 {
+	if configStore != nil {
+		// The user data is a config ID and the store contains the plain JSON.
+		if userDataDecoded, err = resolveConfigID(ctx, data, configStore, logger); err != nil {
+			return nil, err
+		}
+	} else if strings.HasPrefix(data, compressedUserDataPrefix) {
+		// Compressed user data is detected independent of the options, so that URLs keep working when switching between encodings.
+		userDataDecoded, err = decompressUserData(strings.TrimPrefix(data, compressedUserDataPrefix))
+	} else if userDataIsBase64 {
 		// Remove padding so that both Base64URL values with and without padding work.
 		data = strings.TrimSuffix(data, "=")
 		userDataDecoded, err = base64.URLEncoding.WithPadding(base64.NoPadding).DecodeString(data)
@@ -276,11 +480,218 @@ func decodeUserData(data string, t reflect.Type, logger *zap.Logger, userDataIsB
 		return nil, err
 	}
 
+	userData, err := unmarshalUserData(userDataDecoded, t, logger, migrations)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Decoded user data", zap.String("userData", fmt.Sprintf("%+v", userData)))
+	return userData, nil
+}
+
+// compressedUserDataPrefix is the prefix of compressed user data.
+// The "." isn't part of the URL-safe Base64 alphabet and JSON can't start with it, so it's never ambiguous.
+const compressedUserDataPrefix = "d."
+
+// maxDecompressedUserDataSize limits the size of decompressed user data to protect against decompression bombs.
+const maxDecompressedUserDataSize = 1 << 20 // 1 MiB
+
+// decompressUserData decodes URL-safe Base64 and then decompresses the result with DEFLATE.
+func decompressUserData(data string) ([]byte, error) {
+	// Remove padding so that both Base64URL values with and without padding work.
+	data = strings.TrimRight(data, "=")
+	compressed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	decompressed, err := io.ReadAll(io.LimitReader(r, maxDecompressedUserDataSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't decompress user data: %w", err)
+	} else if len(decompressed) > maxDecompressedUserDataSize {
+		return nil, errors.New("decompressed user data is too large")
+	}
+	return decompressed, nil
+}
+
+// encodeUserData marshals the user data to JSON and encodes it the way decodeUserData expects it.
+// Compression takes precedence over Base64, as compressed user data is Base64-encoded as well.
+func encodeUserData(userData interface{}, userDataIsBase64, userDataIsCompressed bool) (string, error) {
+	userDataJSON, err := json.Marshal(userData)
+	if err != nil {
+		return "", fmt.Errorf("Couldn't marshal user data: %w", err)
+	}
+
+	if userDataIsCompressed {
+		buf := bytes.Buffer{}
+		w, err := flate.NewWriter(&buf, flate.BestCompression)
+		if err != nil {
+			return "", fmt.Errorf("Couldn't create DEFLATE writer: %w", err)
+		}
+		if _, err := w.Write(userDataJSON); err != nil {
+			return "", fmt.Errorf("Couldn't compress user data: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return "", fmt.Errorf("Couldn't compress user data: %w", err)
+		}
+		return compressedUserDataPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+	} else if userDataIsBase64 {
+		return base64.RawURLEncoding.EncodeToString(userDataJSON), nil
+	}
+	return url.PathEscape(string(userDataJSON)), nil
+}
+
+// unmarshalUserData migrates the user data JSON to the latest version, unmarshals it into a new object of the given type
+// and validates it if the type implements UserDataValidator.
+func unmarshalUserData(data []byte, t reflect.Type, logger *zap.Logger, migrations map[int]UserDataMigration) (interface{}, error) {
+	if len(migrations) > 0 {
+		var err error
+		if data, err = migrateUserData(data, migrations); err != nil {
+			logger.Warn("Couldn't migrate user data", zap.Error(err))
+			return nil, err
+		}
+	}
+
 	userData := reflect.New(t).Interface()
-	if err := json.Unmarshal(userDataDecoded, userData); err != nil {
+	if err := json.Unmarshal(data, userData); err != nil {
 		logger.Warn("Couldn't unmarshal user data", zap.Error(err))
 		return nil, err
 	}
-	logger.Debug("Decoded user data", zap.String("userData", fmt.Sprintf("%+v", userData)))
+	if validator, ok := userData.(UserDataValidator); ok {
+		if err := validator.Validate(); err != nil {
+			logger.Warn("User data is invalid", zap.Error(err))
+			return nil, err
+		}
+	}
 	return userData, nil
 }
+
+// migrateUserData applies all migrations starting with the version in the user data JSON.
+// User data without a version is treated as version 0.
+// After each migration the version is incremented, so migrations don't have to take care of that.
+func migrateUserData(data []byte, migrations map[int]UserDataMigration) ([]byte, error) {
+	var userData map[string]interface{}
+	if err := json.Unmarshal(data, &userData); err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal user data into map: %w", err)
+	}
+
+	version := 0
+	if versionIface, ok := userData[userDataVersionKey]; ok {
+		versionFloat, ok := versionIface.(float64)
+		if !ok || versionFloat != math.Trunc(versionFloat) {
+			return nil, fmt.Errorf("user data version isn't an integer: %v", versionIface)
+		}
+		version = int(versionFloat)
+	}
+
+	migrated := false
+	for migration, ok := migrations[version]; ok; migration, ok = migrations[version] {
+		var err error
+		if userData, err = migration(userData); err != nil {
+			return nil, fmt.Errorf("Couldn't migrate user data from version %v: %w", version, err)
+		} else if userData == nil {
+			return nil, fmt.Errorf("migration from version %v returned nil", version)
+		}
+		version++
+		userData[userDataVersionKey] = version
+		migrated = true
+	}
+	if !migrated {
+		return data, nil
+	}
+
+	return json.Marshal(userData)
+}
+
+// resolveConfigID looks up the config with the given ID in the config store.
+// A config that's not found is an error, because it's most likely an outdated or manipulated install URL.
+// The returned error is an *Error with status 400 in that case and with status 503 when the store fails.
+func resolveConfigID(ctx context.Context, configID string, configStore ConfigStore, logger *zap.Logger) ([]byte, error) {
+	config, found, err := configStore.Get(ctx, configID)
+	if err != nil {
+		logger.Error("Couldn't get config from config store", zap.Error(err), zap.String("configID", configID))
+		return nil, &Error{Status: fiber.StatusServiceUnavailable, Err: err}
+	} else if !found {
+		logger.Warn("Config not found in config store", zap.String("configID", configID))
+		return nil, &Error{Status: fiber.StatusBadRequest, Message: "Unknown config ID", Err: fmt.Errorf("config %v not found", configID)}
+	}
+	return config, nil
+}
+
+// configureSubmitResponse is the response body of the configure submit handler.
+type configureSubmitResponse struct {
+	ID       string `json:"id,omitempty"`
+	UserData string `json:"userData"`
+	InstallLinks
+}
+
+// createConfigureSubmitHandler creates a handler that turns the JSON request body into user data for install URLs.
+// With a config store the config is stored: Without a "userData" URL parameter a new config with a new ID is created,
+// otherwise the existing config is updated. Without a config store the config is encoded according to the options.
+// The response contains the value for the "userData" URL parameter and the install links with it.
+// With a config store it additionally contains the config ID as "id".
+func createConfigureSubmitHandler(configStore ConfigStore, userDataType reflect.Type, migrations map[int]UserDataMigration, userDataIsBase64, userDataIsCompressed bool, logger *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger.Debug("configureSubmitHandler called")
+
+		config := bytes.Buffer{}
+		if err := json.Compact(&config, c.Body()); err != nil {
+			logger.Warn("Couldn't compact config JSON", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"err": "Invalid JSON"})
+		}
+		// Make sure the config can be decoded into the registered user data type and is valid, so we don't store or encode configs that lead to errors later
+		var userData interface{}
+		if userDataType != nil {
+			var err error
+			if userData, err = unmarshalUserData(config.Bytes(), userDataType, logger, migrations); err != nil {
+				// The error is most likely from the user data's Validate method, which is meant to be shown to users
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"err": err.Error()})
+			}
+		}
+
+		if configStore == nil {
+			if userData == nil {
+				userData = json.RawMessage(config.Bytes())
+			}
+			userDataString, err := encodeUserData(userData, userDataIsBase64, userDataIsCompressed)
+			if err != nil {
+				logger.Error("Couldn't encode user data", zap.Error(err))
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			return c.JSON(configureSubmitResponse{
+				UserData:     userDataString,
+				InstallLinks: newInstallLinks(c.BaseURL(), userDataString),
+			})
+		}
+
+		status := fiber.StatusOK
+		configID := c.Params("userData")
+		if configID == "" {
+			var err error
+			if configID, err = NewConfigID(); err != nil {
+				logger.Error("Couldn't create config ID", zap.Error(err))
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+			status = fiber.StatusCreated
+		} else if _, found, err := configStore.Get(c.Context(), configID); err != nil {
+			logger.Error("Couldn't get config from config store", zap.Error(err), zap.String("configID", configID))
+			return c.SendStatus(fiber.StatusInternalServerError)
+		} else if !found {
+			// Only existing configs can be updated, otherwise anyone could choose their own IDs
+			logger.Warn("Config to update not found in config store", zap.String("configID", configID))
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+
+		if err := configStore.Set(c.Context(), configID, config.Bytes()); err != nil {
+			logger.Error("Couldn't store config", zap.Error(err), zap.String("configID", configID))
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		logger.Debug("Stored config", zap.String("configID", configID))
+		return c.Status(status).JSON(configureSubmitResponse{
+			ID:           configID,
+			UserData:     configID,
+			InstallLinks: newInstallLinks(c.BaseURL(), configID),
+		})
+	}
+}
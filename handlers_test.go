@@ -0,0 +1,427 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testUserData struct {
+	Version int    `json:"version"`
+	Token   string `json:"token"`
+	Quality string `json:"quality"`
+}
+
+func (ud *testUserData) Validate() error {
+	if ud.Token == "" {
+		return errors.New("token is missing")
+	}
+	return nil
+}
+
+func TestDecodeUserData(t *testing.T) {
+	logger := zap.NewNop()
+	ctx := context.Background()
+	userDataType := reflect.TypeOf(testUserData{})
+	migrations := map[int]UserDataMigration{
+		// Version 0 called the token "apiKey"
+		0: func(userData map[string]interface{}) (map[string]interface{}, error) {
+			userData["token"] = userData["apiKey"]
+			delete(userData, "apiKey")
+			return userData, nil
+		},
+		// Version 1 had a boolean instead of the quality string
+		1: func(userData map[string]interface{}) (map[string]interface{}, error) {
+			if hd, _ := userData["hd"].(bool); hd {
+				userData["quality"] = "1080p"
+			} else {
+				userData["quality"] = "720p"
+			}
+			delete(userData, "hd")
+			return userData, nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		expected *testUserData
+		err      bool
+	}{
+		{
+			name:     "current version",
+			data:     `{"version":2,"token":"abc","quality":"720p"}`,
+			expected: &testUserData{Version: 2, Token: "abc", Quality: "720p"},
+		},
+		{
+			name:     "version 1",
+			data:     `{"version":1,"token":"abc","hd":true}`,
+			expected: &testUserData{Version: 2, Token: "abc", Quality: "1080p"},
+		},
+		{
+			name:     "no version",
+			data:     `{"apiKey":"abc"}`,
+			expected: &testUserData{Version: 2, Token: "abc", Quality: "720p"},
+		},
+		{
+			name: "invalid",
+			data: `{"version":2,"quality":"720p"}`,
+			err:  true,
+		},
+		{
+			name: "non-integer version",
+			data: `{"version":"1","token":"abc"}`,
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userData, err := decodeUserData(ctx, test.data, userDataType, logger, false, nil, migrations)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, userData)
+		})
+	}
+}
+
+func TestEncodeUserData(t *testing.T) {
+	logger := zap.NewNop()
+	ctx := context.Background()
+	userDataType := reflect.TypeOf(testUserData{})
+	userData := &testUserData{Version: 2, Token: "abc", Quality: "720p"}
+
+	tests := []struct {
+		name         string
+		isBase64     bool
+		isCompressed bool
+	}{
+		{name: "plain"},
+		{name: "Base64", isBase64: true},
+		{name: "compressed", isCompressed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := encodeUserData(userData, test.isBase64, test.isCompressed)
+			require.NoError(t, err)
+			// Compressed user data must be detected even when the decoder is configured for one of the other encodings
+			for _, isBase64 := range []bool{false, true} {
+				if !test.isCompressed && isBase64 != test.isBase64 {
+					continue
+				}
+				decoded, err := decodeUserData(ctx, encoded, userDataType, logger, isBase64, nil, nil)
+				require.NoError(t, err)
+				require.Equal(t, userData, decoded)
+			}
+		})
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	var handlerErr error
+	streamHandlers := map[string]StreamHandler{
+		"movie": func(_ context.Context, _ string, _ interface{}) ([]StreamItem, error) {
+			return nil, handlerErr
+		},
+	}
+	app := fiber.New()
+	app.Get("/stream/:type/:id.json", createStreamHandler(streamHandlers, handlerOptions{logger: zap.NewNop()}, nil))
+
+	tests := []struct {
+		err        error
+		status     int
+		body       string
+		retryAfter string
+	}{
+		{fmt.Errorf("foo: %w", NotFound), 404, `{"err":"Not found"}`, ""},
+		{&Error{Status: 503, RetryAfter: 1500 * time.Millisecond}, 503, `{"err":"Service Unavailable"}`, "2"},
+		{errors.New("secret internal error"), 500, `{"err":"Internal server error"}`, ""},
+		{&Error{Message: "No status"}, 500, `{"err":"Internal server error"}`, ""},
+		{NewError(200, "OK"), 500, `{"err":"Internal server error"}`, ""},
+	}
+	for _, test := range tests {
+		handlerErr = test.err
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt1.json", nil))
+		require.NoError(t, err)
+		require.Equal(t, test.status, res.StatusCode)
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, test.body, string(body))
+		require.Equal(t, test.retryAfter, res.Header.Get("Retry-After"))
+	}
+
+	// NotFound can lead to an empty, cacheable response instead
+	app = fiber.New()
+	app.Get("/stream/:type/:id.json", createStreamHandler(streamHandlers, handlerOptions{logger: zap.NewNop(), notFoundAsEmpty: true, notFoundCacheAge: time.Hour}, nil))
+	handlerErr = NewError(404, "Unknown ID")
+	res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"streams":[]}`, string(body))
+	require.Equal(t, "max-age=3600, private", res.Header.Get("Cache-Control"))
+
+	// An empty meta response contains no object instead of a list
+	metaHandlers := map[string]MetaHandler{
+		"movie": func(_ context.Context, _ string, _ interface{}) (MetaItem, error) {
+			return MetaItem{}, NotFound
+		},
+	}
+	app = fiber.New()
+	app.Get("/meta/:type/:id.json", createMetaHandler(metaHandlers, handlerOptions{logger: zap.NewNop(), notFoundAsEmpty: true}, nil))
+	res, err = app.Test(httptest.NewRequest("GET", "/meta/movie/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	body, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"meta":null}`, string(body))
+
+	// Notices are rendered as streams linking to the configure page by default
+	app = fiber.New()
+	app.Get("/:userData/stream/:type/:id.json", createStreamHandler(streamHandlers, handlerOptions{logger: zap.NewNop(), noticeCacheAge: time.Minute, hasConfigurePage: true}, nil))
+	handlerErr = fmt.Errorf("foo: %w", NewNotice("Subscription expired"))
+	res, err = app.Test(httptest.NewRequest("GET", "http://example.com/abc/stream/movie/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, res.StatusCode)
+	body, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"streams":[{"externalUrl":"http://example.com/abc/configure","title":"Subscription expired"}]}`, string(body))
+	require.Equal(t, "max-age=60, private", res.Header.Get("Cache-Control"))
+
+	// With a config store the link must contain the config ID, not the stored config
+	configStore := NewInMemoryConfigStore()
+	require.NoError(t, configStore.Set(context.Background(), "abc", []byte(`{"token":"secret"}`)))
+	app = fiber.New()
+	app.Get("/:userData/stream/:type/:id.json", createStreamHandler(streamHandlers, handlerOptions{logger: zap.NewNop(), configStore: configStore, hasConfigurePage: true}, nil))
+	res, err = app.Test(httptest.NewRequest("GET", "http://example.com/abc/stream/movie/tt1.json", nil))
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"streams":[{"externalUrl":"http://example.com/abc/configure","title":"Subscription expired"}]}`, string(body))
+
+	require.True(t, errors.Is(NewError(404, "Unknown ID"), NotFound))
+	require.False(t, errors.Is(NewError(404, "Unknown ID"), BadRequest))
+}
+
+type failingConfigStore struct{}
+
+func (failingConfigStore) Get(_ context.Context, _ string) ([]byte, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (failingConfigStore) Set(_ context.Context, _ string, _ []byte) error {
+	return errors.New("connection refused")
+}
+
+func TestHandlerRequestErrors(t *testing.T) {
+	streamHandlers := map[string]StreamHandler{
+		"movie": func(_ context.Context, _ string, _ interface{}) ([]StreamItem, error) {
+			return nil, nil
+		},
+	}
+	configStore := NewInMemoryConfigStore()
+	require.NoError(t, configStore.Set(context.Background(), "abc", []byte(`{"token":"secret"}`)))
+
+	tests := []struct {
+		name   string
+		opts   handlerOptions
+		target string
+		status int
+		body   string
+	}{
+		{"bad ID", handlerOptions{}, "/x/stream/movie/%zz.json", 400, `{"err":"Invalid ID"}`},
+		{"unhandled type", handlerOptions{}, "/x/stream/series/tt1.json", 404, `{"err":"Unhandled type"}`},
+		{"invalid user data", handlerOptions{userDataType: reflect.TypeOf(testUserData{})}, "/x/stream/movie/tt1.json", 400, `{"err":"Invalid user data"}`},
+		{"unknown config ID", handlerOptions{configStore: configStore}, "/def/stream/movie/tt1.json", 400, `{"err":"Unknown config ID"}`},
+		{"unknown config ID with user data type", handlerOptions{configStore: configStore, userDataType: reflect.TypeOf(testUserData{})}, "/def/stream/movie/tt1.json", 400, `{"err":"Unknown config ID"}`},
+		{"config store failure", handlerOptions{configStore: failingConfigStore{}}, "/abc/stream/movie/tt1.json", 503, `{"err":"Service Unavailable"}`},
+		{"config store failure with user data type", handlerOptions{configStore: failingConfigStore{}, userDataType: reflect.TypeOf(testUserData{})}, "/abc/stream/movie/tt1.json", 503, `{"err":"Service Unavailable"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.logger = zap.NewNop()
+			app := fiber.New()
+			app.Get("/:userData/stream/:type/:id.json", createStreamHandler(streamHandlers, test.opts, nil))
+			// The request URI is set directly, because NewRequest doesn't allow invalid escapes
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RequestURI = test.target
+			res, err := app.Test(req)
+			require.NoError(t, err)
+			require.Equal(t, test.status, res.StatusCode)
+			body, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, test.body, string(body))
+		})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	streamHandlers := map[string]StreamHandler{
+		"movie": func(_ context.Context, id string, _ interface{}) ([]StreamItem, error) {
+			return []StreamItem{{URL: "http://example.com/" + id, Title: "1080p"}, {URL: "http://example.com/bad"}}, nil
+		},
+	}
+	beforeHooks := []BeforeHook{
+		func(_ context.Context, resource, t, id string, userData interface{}) (string, interface{}, error) {
+			if id == "tt0" {
+				return "", nil, NotFound
+			}
+			return id + "-changed", userData, nil
+		},
+	}
+	afterHooks := []StreamAfterHook{
+		func(_ context.Context, t, id string, _ interface{}, streams []StreamItem) ([]StreamItem, error) {
+			streams = streams[:1]
+			streams[0].Title = "My addon " + streams[0].Title
+			return streams, nil
+		},
+	}
+	app := fiber.New()
+	app.Get("/stream/:type/:id.json", createStreamHandler(streamHandlers, handlerOptions{logger: zap.NewNop(), beforeHooks: beforeHooks}, afterHooks))
+
+	res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt1.json", nil))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"streams":[{"url":"http://example.com/tt1-changed","title":"My addon 1080p"}]}`, string(body))
+
+	// Before hooks can short-circuit
+	res, err = app.Test(httptest.NewRequest("GET", "/stream/movie/tt0.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, 404, res.StatusCode)
+
+	// Meta after hooks get the handler's meta item and can change it
+	metaHandlers := map[string]MetaHandler{
+		"movie": func(_ context.Context, id string, _ interface{}) (MetaItem, error) {
+			return MetaItem{ID: id, Type: "movie", Name: "The Matrix"}, nil
+		},
+	}
+	metaAfterHooks := []MetaAfterHook{
+		func(_ context.Context, t, id string, _ interface{}, meta MetaItem) (MetaItem, error) {
+			meta.Description = "From my addon"
+			return meta, nil
+		},
+	}
+	app.Get("/meta/:type/:id.json", createMetaHandler(metaHandlers, handlerOptions{logger: zap.NewNop(), beforeHooks: beforeHooks}, metaAfterHooks))
+
+	res, err = app.Test(httptest.NewRequest("GET", "/meta/movie/tt1.json", nil))
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"meta":{"id":"tt1-changed","type":"movie","name":"The Matrix","description":"From my addon"}}`, string(body))
+}
+
+func TestHandlerMeta(t *testing.T) {
+	streamHandlers := map[string]StreamHandler{
+		"movie": func(ctx context.Context, _ string, _ interface{}) ([]StreamItem, error) {
+			meta, err := cinemeta.GetMetaFromContext(ctx)
+			if err != nil {
+				return nil, NotFound
+			}
+			return []StreamItem{{URL: "http://example.com/1", Title: meta.Name}}, nil
+		},
+	}
+	for _, putMetaInContext := range []bool{true, false} {
+		app := fiber.New()
+		// Media name logging fetches the meta concurrently to the handler when it's not put into its context
+		app.Use("/stream/:type/:id.json", createMetaMiddleware(staticMetaFetcher{name: "The Matrix"}, putMetaInContext, true, "stream", "", zap.NewNop()))
+		app.Get("/stream/:type/:id.json", createStreamHandler(streamHandlers, handlerOptions{logger: zap.NewNop(), putMetaInContext: putMetaInContext}, nil))
+
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt0133093.json", nil))
+		require.NoError(t, err)
+		if putMetaInContext {
+			require.Equal(t, 200, res.StatusCode)
+			body, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			require.Equal(t, `{"streams":[{"url":"http://example.com/1","title":"The Matrix"}]}`, string(body))
+		} else {
+			require.Equal(t, 404, res.StatusCode)
+		}
+	}
+}
+
+func TestMetaAndSubtitlesRequests(t *testing.T) {
+	// The handlers respond with the name from the meta in their context
+	metaName := func(ctx context.Context) string {
+		meta, err := cinemeta.GetMetaFromContext(ctx)
+		if err != nil {
+			return ""
+		}
+		return meta.Name
+	}
+	catalogHandlers := map[string]CatalogHandler{
+		"movie": func(ctx context.Context, _ string, _ interface{}) ([]MetaPreviewItem, error) {
+			return []MetaPreviewItem{{ID: "tt1", Type: "movie", Name: "Similar to " + metaName(ctx)}}, nil
+		},
+	}
+	manifest := Manifest{
+		ID:            "com.example.test",
+		Name:          "Test",
+		Description:   "Test",
+		Version:       "0.1.0",
+		ResourceItems: []ResourceItem{{Name: "meta", Types: []string{"movie", "series"}}, {Name: "subtitles", Types: []string{"movie"}}},
+		Catalogs:      []CatalogItem{{Type: "movie", ID: "similar", Name: "Similar", Extra: []ExtraItem{{Name: "similarTo", IsRequired: true}}}},
+	}
+	opts := Options{
+		Logger:           zap.NewNop(),
+		MetaClient:       staticMetaFetcher{name: "The Matrix"},
+		PutMetaInContext: true,
+		CatalogMetaExtra: "similarTo",
+		CacheAgeMeta:     time.Hour,
+	}
+	addon, err := NewAddon(manifest, catalogHandlers, nil, opts)
+	require.NoError(t, err)
+	addon.SetMetaHandlers(map[string]MetaHandler{
+		"movie": func(ctx context.Context, id string, _ interface{}) (MetaItem, error) {
+			return MetaItem{ID: id, Type: "movie", Name: metaName(ctx)}, nil
+		},
+		"series": func(ctx context.Context, id string, _ interface{}) (MetaItem, error) {
+			return MetaItem{}, &Notice{Message: "Expired", URL: "http://example.com"}
+		},
+	})
+	addon.SetSubtitlesHandlers(map[string]SubtitlesHandler{
+		"movie": func(ctx context.Context, id string, _ interface{}) ([]SubtitleItem, error) {
+			lang := GetCatalogExtra(ctx).Values.Get("videoHash") + " " + metaName(ctx)
+			return []SubtitleItem{{ID: "1", URL: "http://example.com/1.srt", Lang: lang}}, nil
+		},
+	})
+	app := addon.createApp()
+
+	for _, test := range []struct {
+		path     string
+		wantBody string
+	}{
+		{"/meta/movie/tt0133093.json", `{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`},
+		{"/foo/meta/movie/tt0133093.json", `{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`},
+		{"/meta/series/tt0944947.json", `{"meta":{"id":"tt0944947","type":"series","name":"Expired","website":"http://example.com"}}`},
+		{"/subtitles/movie/tt0133093.json", `{"subtitles":[{"id":"1","url":"http://example.com/1.srt","lang":" The Matrix"}]}`},
+		{"/subtitles/movie/tt0133093/videoHash=abc&videoSize=123.json", `{"subtitles":[{"id":"1","url":"http://example.com/1.srt","lang":"abc The Matrix"}]}`},
+		{"/catalog/movie/similar/similarTo=tt0133093.json", `{"metas":[{"id":"tt1","type":"movie","name":"Similar to The Matrix","poster":""}]}`},
+		// Catalog requests without the extra don't get meta
+		{"/catalog/movie/similar.json", `{"metas":[{"id":"tt1","type":"movie","name":"Similar to ","poster":""}]}`},
+	} {
+		res, err := app.Test(httptest.NewRequest("GET", test.path, nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode, test.path)
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, test.wantBody, string(body), test.path)
+	}
+
+	res, err := app.Test(httptest.NewRequest("GET", "/meta/movie/tt0133093.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, "max-age=3600, private", res.Header.Get("Cache-Control"))
+}
@@ -0,0 +1,62 @@
+package stremio
+
+import (
+	"context"
+)
+
+// BeforeHook is called before the catalog, stream, meta and subtitles handlers, for logic that applies to all of them.
+// The resource parameter is "catalog", "stream", "meta" or "subtitles", the other parameters are the same as the handlers get.
+// It returns the ID and user data that are passed to the next hook or the handler, so it can change them.
+// Returning an error skips the handler, and the error is handled like an error from the handler,
+// so for example returning NotFound, an *Error or a *Notice controls the response.
+type BeforeHook func(ctx context.Context, resource, t, id string, userData interface{}) (string, interface{}, error)
+
+// CatalogAfterHook is called with the results of the catalog handler and returns the results for the response,
+// so it can filter, sort or modify them. With EnrichCatalogs being set, it's called after the enrichment.
+// Returning an error is handled like an error from the handler.
+type CatalogAfterHook func(ctx context.Context, t, id string, userData interface{}, metas []MetaPreviewItem) ([]MetaPreviewItem, error)
+
+// StreamAfterHook is called with the results of the stream handler and returns the results for the response,
+// so it can filter, sort or modify them, for example to add the addon's name to all titles.
+// Returning an error is handled like an error from the handler.
+type StreamAfterHook func(ctx context.Context, t, id string, userData interface{}, streams []StreamItem) ([]StreamItem, error)
+
+// MetaAfterHook is called with the result of the meta handler and returns the result for the response,
+// so it can modify it, for example to add a link to the addon's website to the description.
+// Returning an error is handled like an error from the handler.
+type MetaAfterHook func(ctx context.Context, t, id string, userData interface{}, meta MetaItem) (MetaItem, error)
+
+// AddBeforeHook appends a hook that's called before the catalog, stream, meta and subtitles handlers.
+// Hooks are called in the order they were added.
+func (a *Addon) AddBeforeHook(hook BeforeHook) {
+	a.beforeHooks = append(a.beforeHooks, hook)
+}
+
+// AddCatalogAfterHook appends a hook that's called with the results of the catalog handlers.
+// Hooks are called in the order they were added, each with the results of the previous one.
+func (a *Addon) AddCatalogAfterHook(hook CatalogAfterHook) {
+	a.catalogAfterHooks = append(a.catalogAfterHooks, hook)
+}
+
+// AddStreamAfterHook appends a hook that's called with the results of the stream handlers.
+// Hooks are called in the order they were added, each with the results of the previous one.
+func (a *Addon) AddStreamAfterHook(hook StreamAfterHook) {
+	a.streamAfterHooks = append(a.streamAfterHooks, hook)
+}
+
+// AddMetaAfterHook appends a hook that's called with the results of the meta handlers.
+// Hooks are called in the order they were added, each with the result of the previous one.
+func (a *Addon) AddMetaAfterHook(hook MetaAfterHook) {
+	a.metaAfterHooks = append(a.metaAfterHooks, hook)
+}
+
+// runBeforeHooks calls the hooks in order and returns the resulting ID and user data.
+func runBeforeHooks(ctx context.Context, hooks []BeforeHook, resource, t, id string, userData interface{}) (string, interface{}, error) {
+	for _, hook := range hooks {
+		var err error
+		if id, userData, err = hook(ctx, resource, t, id, userData); err != nil {
+			return "", nil, err
+		}
+	}
+	return id, userData, nil
+}
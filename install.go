@@ -0,0 +1,98 @@
+package stremio
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// stremioWebInstallURL is the URL of Stremio Web that opens the addon installation dialog for the manifest URL appended to it.
+const stremioWebInstallURL = "https://web.stremio.com/#/addons?addon="
+
+// InstallLinks contains the URLs that users can use for installing the addon.
+type InstallLinks struct {
+	// URL of the manifest, e.g. "https://example.com/abc/manifest.json".
+	// Users can paste it into Stremio's addon search.
+	ManifestURL string `json:"manifestURL"`
+	// Deep link that opens the installation dialog in a locally installed Stremio app, e.g. "stremio://example.com/abc/manifest.json".
+	StremioURL string `json:"stremioURL"`
+	// URL that opens the installation dialog in Stremio Web.
+	WebURL string `json:"webURL"`
+}
+
+// newInstallLinks creates the install links for the given base URL and the already encoded user data, which can be empty.
+func newInstallLinks(baseURL, userData string) InstallLinks {
+	manifestURL := strings.TrimSuffix(baseURL, "/")
+	if userData != "" {
+		manifestURL += "/" + userData
+	}
+	manifestURL += "/manifest.json"
+
+	stremioURL := manifestURL
+	if i := strings.Index(stremioURL, "://"); i != -1 {
+		stremioURL = stremioURL[i+3:]
+	}
+	stremioURL = "stremio://" + stremioURL
+
+	return InstallLinks{
+		ManifestURL: manifestURL,
+		StremioURL:  stremioURL,
+		WebURL:      stremioWebInstallURL + url.QueryEscape(manifestURL),
+	}
+}
+
+// InstallLinks returns the links for installing the addon, with the user data encoded the same way the addon decodes it.
+// The baseURL is the public URL of the addon, e.g. "https://example.com", which might differ from the bind address if you use a reverse proxy.
+// The userData can be nil for links without user data. If it's a string, it's used as-is in the URL,
+// so it must be already encoded user data, like the result of `EncodeUserData()` or a config ID.
+// Otherwise it's encoded according to the options like with `EncodeUserData()`.
+// When using a ConfigStore, store the config yourself and pass its config ID, because encoding user data isn't possible then.
+func (a *Addon) InstallLinks(baseURL string, userData interface{}) (InstallLinks, error) {
+	var userDataString string
+	switch ud := userData.(type) {
+	case nil:
+	case string:
+		userDataString = ud
+	default:
+		var err error
+		if userDataString, err = a.EncodeUserData(userData); err != nil {
+			return InstallLinks{}, err
+		}
+	}
+	return newInstallLinks(baseURL, userDataString), nil
+}
+
+// createInstallHandler creates a handler that redirects to an install link for the request's user data.
+// The "target" query parameter selects the link: "stremio" (default), "web" or "manifest".
+// When the addon requires a configuration and the request has no user data, it redirects to the configure page instead.
+func createInstallHandler(configurationRequired bool, logger *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger.Debug("installHandler called")
+
+		userData := c.Params("userData")
+		if userData == "" && configurationRequired {
+			c.Set(fiber.HeaderLocation, c.BaseURL()+"/configure")
+			return c.SendStatus(fiber.StatusTemporaryRedirect)
+		}
+
+		links := newInstallLinks(c.BaseURL(), userData)
+		var redirectURL string
+		switch target := c.Query("target", "stremio"); target {
+		case "stremio":
+			redirectURL = links.StremioURL
+		case "web":
+			redirectURL = links.WebURL
+		case "manifest":
+			redirectURL = links.ManifestURL
+		default:
+			logger.Debug("Got install request for unknown target", zap.String("target", target))
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+
+		logger.Debug("Responding with redirect", zap.String("redirectURL", redirectURL))
+		c.Set(fiber.HeaderLocation, redirectURL)
+		return c.SendStatus(fiber.StatusTemporaryRedirect)
+	}
+}
@@ -0,0 +1,77 @@
+package stremio
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestInstallLinks(t *testing.T) {
+	manifest := Manifest{
+		ID:          "com.example.test",
+		Name:        "Test",
+		Description: "Test",
+		Version:     "0.1.0",
+	}
+	addon, err := NewAddon(manifest, nil, map[string]StreamHandler{}, Options{Logger: zap.NewNop()})
+	require.NoError(t, err)
+
+	links, err := addon.InstallLinks("https://example.com/", nil)
+	require.NoError(t, err)
+	require.Equal(t, InstallLinks{
+		ManifestURL: "https://example.com/manifest.json",
+		StremioURL:  "stremio://example.com/manifest.json",
+		WebURL:      "https://web.stremio.com/#/addons?addon=" + url.QueryEscape("https://example.com/manifest.json"),
+	}, links)
+
+	// Encoded user data must be used as-is, so that it's not escaped twice
+	userData := map[string]string{"token": "secret"}
+	encoded, err := addon.EncodeUserData(userData)
+	require.NoError(t, err)
+	links, err = addon.InstallLinks("https://example.com", encoded)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/%7B%22token%22:%22secret%22%7D/manifest.json", links.ManifestURL)
+	linksFromObject, err := addon.InstallLinks("https://example.com", userData)
+	require.NoError(t, err)
+	require.Equal(t, links, linksFromObject)
+
+	// With a config store the caller must store the config and pass the config ID
+	addon, err = NewAddon(manifest, nil, map[string]StreamHandler{}, Options{Logger: zap.NewNop(), ConfigStore: NewInMemoryConfigStore()})
+	require.NoError(t, err)
+	_, err = addon.InstallLinks("https://example.com", userData)
+	require.Error(t, err)
+	links, err = addon.InstallLinks("https://example.com", "abc")
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/abc/manifest.json", links.ManifestURL)
+}
+
+func TestInstallHandler(t *testing.T) {
+	for _, test := range []struct {
+		configurationRequired bool
+		path                  string
+		wantStatus            int
+		wantLocation          string
+	}{
+		{false, "/install", 307, "stremio://example.com/manifest.json"},
+		{false, "/abc/install", 307, "stremio://example.com/abc/manifest.json"},
+		{false, "/abc/install?target=manifest", 307, "http://example.com/abc/manifest.json"},
+		{false, "/abc/install?target=web", 307, "https://web.stremio.com/#/addons?addon=" + url.QueryEscape("http://example.com/abc/manifest.json")},
+		{false, "/abc/install?target=foo", 400, ""},
+		{true, "/install", 307, "http://example.com/configure"},
+		{true, "/abc/install", 307, "stremio://example.com/abc/manifest.json"},
+	} {
+		app := fiber.New()
+		installHandler := createInstallHandler(test.configurationRequired, zap.NewNop())
+		app.Get("/install", installHandler)
+		app.Get("/:userData/install", installHandler)
+
+		res, err := app.Test(httptest.NewRequest("GET", "http://example.com"+test.path, nil))
+		require.NoError(t, err)
+		require.Equal(t, test.wantStatus, res.StatusCode, test.path)
+		require.Equal(t, test.wantLocation, res.Header.Get("Location"), test.path)
+	}
+}
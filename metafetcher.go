@@ -0,0 +1,166 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+)
+
+// IDSchemeIMDb is the ID scheme of IMDb IDs like "tt0944947", which don't have a prefix.
+const IDSchemeIMDb = "imdb"
+
+// ErrUnsupportedIDScheme is returned by a MetaRouter for IDs whose scheme has no MetaFetcher.
+var ErrUnsupportedIDScheme = errors.New("no MetaFetcher for ID scheme")
+
+// MediaID is a parsed movie or TV show episode ID from a Stremio request.
+type MediaID struct {
+	// Scheme of the ID, e.g. "imdb" for "tt0944947" or "kitsu" for "kitsu:123:4".
+	Scheme string
+	// ID of the movie or TV show without season and episode, e.g. "tt0944947" or "kitsu:123".
+	// This is the ID that's passed to a MetaFetcher.
+	ID string
+	// Season of the TV show episode.
+	// 0 for movies and for schemes that only have an episode number, like "kitsu:123:4".
+	Season int
+	// Episode of the TV show episode. 0 for movies.
+	Episode int
+}
+
+// ParseMediaID parses the ID of a stream (or similar) request for the given type ("movie" or "series").
+// IMDb IDs have the formats "tt0133093" for movies and "tt0944947:1:2" for TV show episodes.
+// IDs of other schemes are prefixed with the scheme and can have an episode number or season and episode number,
+// e.g. "tmdb:603", "kitsu:123:4" or "tmdb:1399:1:2".
+func ParseMediaID(t, id string) (MediaID, error) {
+	parts := strings.Split(id, ":")
+	mediaID := MediaID{
+		Scheme: IDSchemeIMDb,
+	}
+	if strings.HasPrefix(id, "tt") {
+		mediaID.ID = parts[0]
+		parts = parts[1:]
+	} else {
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return MediaID{}, fmt.Errorf("ID %q is neither an IMDb ID nor has the \"scheme:id\" format", id)
+		}
+		mediaID.Scheme = parts[0]
+		mediaID.ID = parts[0] + ":" + parts[1]
+		parts = parts[2:]
+	}
+
+	switch {
+	case t != "series" && len(parts) == 0:
+	case t != "series":
+		return MediaID{}, fmt.Errorf("Movie ID %q has unexpected parts", id)
+	// Only IMDb IDs always have a season, other schemes can have only episodes
+	case len(parts) == 1 && mediaID.Scheme != IDSchemeIMDb:
+		episode, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return MediaID{}, fmt.Errorf("Can't parse episode of ID %q as int: %w", id, err)
+		}
+		mediaID.Episode = episode
+	case len(parts) == 2:
+		season, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return MediaID{}, fmt.Errorf("Can't parse season of ID %q as int: %w", id, err)
+		}
+		episode, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return MediaID{}, fmt.Errorf("Can't parse episode of ID %q as int: %w", id, err)
+		}
+		mediaID.Season, mediaID.Episode = season, episode
+	default:
+		return MediaID{}, fmt.Errorf("TV show ID %q has an unexpected number of parts", id)
+	}
+
+	return mediaID, nil
+}
+
+var _ MetaFetcher = (*MetaRouter)(nil)
+
+// MetaRouter is a MetaFetcher that dispatches requests by the ID scheme to other MetaFetchers,
+// so that for example IMDb IDs are handled by the Cinemeta client and "kitsu:" IDs by a client for Kitsu.
+type MetaRouter struct {
+	fetchers map[string]MetaFetcher
+	fallback MetaFetcher
+}
+
+// NewMetaRouter creates a new MetaRouter.
+// The keys of the map are ID schemes like IDSchemeIMDb or "kitsu".
+// The fallback is used for IDs with other schemes. If it's nil, such requests lead to an ErrUnsupportedIDScheme.
+func NewMetaRouter(fetchers map[string]MetaFetcher, fallback MetaFetcher) *MetaRouter {
+	return &MetaRouter{
+		fetchers: fetchers,
+		fallback: fallback,
+	}
+}
+
+// GetMovie calls GetMovie on the MetaFetcher for the ID's scheme.
+func (r *MetaRouter) GetMovie(ctx context.Context, id string) (cinemeta.Meta, error) {
+	fetcher, err := r.route(id)
+	if err != nil {
+		return cinemeta.Meta{}, err
+	}
+	return fetcher.GetMovie(ctx, id)
+}
+
+// GetTVShow calls GetTVShow on the MetaFetcher for the ID's scheme.
+func (r *MetaRouter) GetTVShow(ctx context.Context, id string, season int, episode int) (cinemeta.Meta, error) {
+	fetcher, err := r.route(id)
+	if err != nil {
+		return cinemeta.Meta{}, err
+	}
+	return fetcher.GetTVShow(ctx, id, season, episode)
+}
+
+func (r *MetaRouter) route(id string) (MetaFetcher, error) {
+	scheme := IDSchemeIMDb
+	if !strings.HasPrefix(id, "tt") {
+		scheme = strings.SplitN(id, ":", 2)[0]
+	}
+	if fetcher, ok := r.fetchers[scheme]; ok {
+		return fetcher, nil
+	} else if r.fallback != nil {
+		return r.fallback, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedIDScheme, scheme)
+}
+
+var _ MetaFetcher = MetaFetcherChain(nil)
+
+// MetaFetcherChain is a MetaFetcher that tries the MetaFetchers in order and returns the first successful result,
+// for example to fall back to an offline dump when Cinemeta isn't reachable.
+type MetaFetcherChain []MetaFetcher
+
+// GetMovie calls GetMovie on the MetaFetchers until one succeeds.
+// If all fail, the error of the last one is returned.
+func (c MetaFetcherChain) GetMovie(ctx context.Context, id string) (cinemeta.Meta, error) {
+	return c.try(func(fetcher MetaFetcher) (cinemeta.Meta, error) {
+		return fetcher.GetMovie(ctx, id)
+	})
+}
+
+// GetTVShow calls GetTVShow on the MetaFetchers until one succeeds.
+// If all fail, the error of the last one is returned.
+func (c MetaFetcherChain) GetTVShow(ctx context.Context, id string, season int, episode int) (cinemeta.Meta, error) {
+	return c.try(func(fetcher MetaFetcher) (cinemeta.Meta, error) {
+		return fetcher.GetTVShow(ctx, id, season, episode)
+	})
+}
+
+func (c MetaFetcherChain) try(get func(MetaFetcher) (cinemeta.Meta, error)) (cinemeta.Meta, error) {
+	if len(c) == 0 {
+		return cinemeta.Meta{}, errors.New("No MetaFetchers in chain")
+	}
+	var err error
+	for _, fetcher := range c {
+		var meta cinemeta.Meta
+		if meta, err = get(fetcher); err == nil {
+			return meta, nil
+		}
+	}
+	return cinemeta.Meta{}, fmt.Errorf("All %v MetaFetchers failed, the last one with: %w", len(c), err)
+}
@@ -0,0 +1,71 @@
+package stremio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMediaID(t *testing.T) {
+	tests := []struct {
+		t       string
+		id      string
+		want    MediaID
+		wantErr bool
+	}{
+		{"movie", "tt0133093", MediaID{Scheme: "imdb", ID: "tt0133093"}, false},
+		{"series", "tt0944947:1:2", MediaID{Scheme: "imdb", ID: "tt0944947", Season: 1, Episode: 2}, false},
+		{"series", "kitsu:123:4", MediaID{Scheme: "kitsu", ID: "kitsu:123", Episode: 4}, false},
+		{"series", "tmdb:1399:1:2", MediaID{Scheme: "tmdb", ID: "tmdb:1399", Season: 1, Episode: 2}, false},
+		{"movie", "tmdb:603", MediaID{Scheme: "tmdb", ID: "tmdb:603"}, false},
+		{"series", "tt0944947:1", MediaID{}, true},
+		{"series", "tt0944947:a:2", MediaID{}, true},
+		{"movie", "tt0133093:1:2", MediaID{}, true},
+		{"movie", "foo", MediaID{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseMediaID(test.t, test.id)
+		if test.wantErr {
+			require.Error(t, err, test.id)
+		} else {
+			require.NoError(t, err, test.id)
+		}
+		require.Equal(t, test.want, got, test.id)
+	}
+}
+
+type staticMetaFetcher struct {
+	name string
+	err  error
+}
+
+func (f staticMetaFetcher) GetMovie(_ context.Context, id string) (cinemeta.Meta, error) {
+	return cinemeta.Meta{ID: id, Name: f.name}, f.err
+}
+
+func (f staticMetaFetcher) GetTVShow(_ context.Context, id string, _ int, _ int) (cinemeta.Meta, error) {
+	return cinemeta.Meta{ID: id, Name: f.name}, f.err
+}
+
+func TestMetaRouterAndChain(t *testing.T) {
+	ctx := context.Background()
+	router := NewMetaRouter(map[string]MetaFetcher{
+		IDSchemeIMDb: staticMetaFetcher{name: "imdb"},
+		// The first fetcher fails, so the second one must be used
+		"kitsu": MetaFetcherChain{staticMetaFetcher{err: cinemeta.ErrNotFound}, staticMetaFetcher{name: "kitsu"}},
+	}, nil)
+
+	meta, err := router.GetMovie(ctx, "tt0133093")
+	require.NoError(t, err)
+	require.Equal(t, "imdb", meta.Name)
+	meta, err = router.GetTVShow(ctx, "kitsu:123", 0, 4)
+	require.NoError(t, err)
+	require.Equal(t, "kitsu", meta.Name)
+	_, err = router.GetMovie(ctx, "tmdb:603")
+	require.ErrorIs(t, err, ErrUnsupportedIDScheme)
+
+	_, err = MetaFetcherChain{staticMetaFetcher{err: cinemeta.ErrNotFound}}.GetMovie(ctx, "tt0133093")
+	require.ErrorIs(t, err, cinemeta.ErrNotFound)
+}
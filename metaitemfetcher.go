@@ -0,0 +1,138 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+)
+
+// MetaItemFunc returns the meta item of a movie ("movie") or TV show ("series") by its ID without season and episode.
+// It's the function that your addon uses to respond to meta requests for its own IDs,
+// for example in a custom endpoint for "/meta/:type/:id.json".
+type MetaItemFunc func(ctx context.Context, t, id string) (MetaItem, error)
+
+var _ MetaFetcher = (*MetaItemFetcher)(nil)
+
+// MetaItemFetcher is a MetaFetcher that gets the meta from your addon's own MetaItemFunc,
+// so that addons with custom IDs get media names in logs and meta in the context without a second metadata source.
+// Results are cached in memory, with the cache's metrics named "meta_item_cache_*" instead of "cinemeta_cache_*".
+// NotFound errors from the MetaItemFunc are cached for a shorter time, like the Cinemeta client's negative cache does,
+// and lead to errors wrapping cinemeta.ErrNotFound. Meta items without a name are treated like NotFound, like the Cinemeta client does.
+// With the MetaFromMetaHandlers option, go-stremio creates one that uses the addon's meta handlers.
+type MetaItemFetcher struct {
+	metaItemFunc MetaItemFunc
+	cache        *cinemeta.LRUCache
+	notFoundTTL  time.Duration
+}
+
+// metaItemNotFoundTTL is the time for which the MetaItemFetcher caches NotFound errors, unless its TTL is shorter.
+const metaItemNotFoundTTL = 5 * time.Minute
+
+// NewMetaItemFetcher creates a new MetaItemFetcher.
+// The cache capacity is the maximum number of movies / TV shows in the cache and must be greater than 0.
+// The TTL is the max age of cached items. 0 means that items don't expire and are only evicted when the cache is full.
+func NewMetaItemFetcher(metaItemFunc MetaItemFunc, cacheCapacity int, ttl time.Duration) *MetaItemFetcher {
+	notFoundTTL := metaItemNotFoundTTL
+	if ttl != 0 && ttl < notFoundTTL {
+		notFoundTTL = ttl
+	}
+	return &MetaItemFetcher{
+		metaItemFunc: metaItemFunc,
+		cache:        cinemeta.NewLRUCacheWithOptions(cacheCapacity, ttl, cinemeta.LRUCacheOptions{MetricsPrefix: "meta_item_cache"}),
+		notFoundTTL:  notFoundTTL,
+	}
+}
+
+// GetMovie returns the meta of the movie from the cache or the MetaItemFunc.
+func (f *MetaItemFetcher) GetMovie(ctx context.Context, id string) (cinemeta.Meta, error) {
+	return f.getMeta(ctx, "movie", id)
+}
+
+// GetTVShow returns the meta of the TV show from the cache or the MetaItemFunc.
+// The returned meta's Episode field contains the requested episode, if the meta item's videos contain it.
+func (f *MetaItemFetcher) GetTVShow(ctx context.Context, id string, season int, episode int) (cinemeta.Meta, error) {
+	meta, err := f.getMeta(ctx, "series", id)
+	if err != nil {
+		return cinemeta.Meta{}, err
+	}
+	for _, video := range meta.Videos {
+		if video.Season == season && video.Episode == episode {
+			meta.Episode = &video
+			break
+		}
+	}
+	return meta, nil
+}
+
+func (f *MetaItemFetcher) getMeta(ctx context.Context, t, id string) (cinemeta.Meta, error) {
+	cacheKey := t + ":" + id
+	// The LRUCache never returns errors
+	if meta, _, found, _ := f.cache.Get(ctx, cacheKey); found {
+		// An empty meta signals a cached NotFound
+		if meta.Name == "" {
+			return cinemeta.Meta{}, fmt.Errorf("Meta item not found (cached): %w", cinemeta.ErrNotFound)
+		}
+		return meta, nil
+	}
+
+	metaItem, err := f.metaItemFunc(ctx, t, id)
+	if errors.Is(err, NotFound) || (err == nil && metaItem.Name == "") {
+		_ = f.cache.Set(ctx, cacheKey, cinemeta.Meta{}, f.notFoundTTL)
+		return cinemeta.Meta{}, fmt.Errorf("Meta item not found: %w", cinemeta.ErrNotFound)
+	} else if err != nil {
+		return cinemeta.Meta{}, fmt.Errorf("Couldn't get meta item: %w", err)
+	}
+	meta := cinemetaFromMetaItem(metaItem)
+	_ = f.cache.Set(ctx, cacheKey, meta, 0)
+	return meta, nil
+}
+
+// cinemetaFromMetaItem converts a MetaItem to a Cinemeta meta object. It's the reverse of MetaItemFromCinemeta.
+// Seasons and episodes of videos that aren't numbers are converted to 0.
+func cinemetaFromMetaItem(metaItem MetaItem) cinemeta.Meta {
+	var videos []cinemeta.Video
+	if metaItem.Videos != nil {
+		videos = make([]cinemeta.Video, len(metaItem.Videos))
+		for i, videoItem := range metaItem.Videos {
+			season, _ := strconv.Atoi(videoItem.Season)
+			episode, _ := strconv.Atoi(videoItem.Episode)
+			videos[i] = cinemeta.Video{
+				ID:        videoItem.ID,
+				Name:      videoItem.Title,
+				Season:    season,
+				Episode:   episode,
+				Released:  videoItem.Released,
+				Overview:  videoItem.Overview,
+				Thumbnail: videoItem.Thumbnail,
+			}
+		}
+	}
+
+	return cinemeta.Meta{
+		ID:   metaItem.ID,
+		Type: metaItem.Type,
+		Name: metaItem.Name,
+
+		Genres:      copyStrings(metaItem.Genres),
+		Director:    copyStrings(metaItem.Director),
+		Cast:        copyStrings(metaItem.Cast),
+		Poster:      metaItem.Poster,
+		PosterShape: metaItem.PosterShape,
+		Background:  metaItem.Background,
+		Logo:        metaItem.Logo,
+		Description: metaItem.Description,
+		ReleaseInfo: metaItem.ReleaseInfo,
+		IMDbRating:  metaItem.IMDbRating,
+		Released:    metaItem.Released,
+		Runtime:     metaItem.Runtime,
+		Language:    metaItem.Language,
+		Country:     metaItem.Country,
+		Awards:      metaItem.Awards,
+		Website:     metaItem.Website,
+		Videos:      videos,
+	}
+}
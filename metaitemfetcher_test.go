@@ -0,0 +1,94 @@
+package stremio
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMetaItemFetcher(t *testing.T) {
+	calls := 0
+	f := NewMetaItemFetcher(func(_ context.Context, t, id string) (MetaItem, error) {
+		calls++
+		return MetaItem{ID: id, Type: t, Name: "Show", Videos: []VideoItem{{ID: id + ":1:2", Title: "Episode", Season: "1", Episode: "2"}}}, nil
+	}, 10, 0)
+
+	meta, err := f.GetTVShow(context.Background(), "custom:1", 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, "Show", meta.Name)
+	require.NotNil(t, meta.Episode)
+	require.Equal(t, "Episode", meta.Episode.Name)
+
+	// The second call must be served from the cache
+	_, err = f.GetTVShow(context.Background(), "custom:1", 1, 3)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	// NotFound is cached as well
+	calls = 0
+	f = NewMetaItemFetcher(func(_ context.Context, _, _ string) (MetaItem, error) {
+		calls++
+		return MetaItem{}, NotFound
+	}, 10, 0)
+	for i := 0; i < 2; i++ {
+		_, err = f.GetMovie(context.Background(), "custom:2")
+		require.ErrorIs(t, err, cinemeta.ErrNotFound)
+	}
+	require.Equal(t, 1, calls)
+}
+
+func TestMetaFromMetaHandlers(t *testing.T) {
+	streamHandlers := map[string]StreamHandler{
+		"series": func(ctx context.Context, _ string, _ interface{}) ([]StreamItem, error) {
+			meta, err := cinemeta.GetMetaFromContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return []StreamItem{{URL: "http://example.com/1", Title: meta.Name + " - " + meta.Episode.Name}}, nil
+		},
+	}
+	manifest := Manifest{
+		ID:          "com.example.test",
+		Name:        "Test",
+		Description: "Test",
+		Version:     "0.1.0",
+	}
+	opts := Options{
+		Logger:               zap.NewNop(),
+		PutMetaInContext:     true,
+		MetaFromMetaHandlers: true,
+	}
+	addon, err := NewAddon(manifest, nil, streamHandlers, opts)
+	require.NoError(t, err)
+	calls := 0
+	addon.SetMetaHandlers(map[string]MetaHandler{
+		"series": func(_ context.Context, id string, userData interface{}) (MetaItem, error) {
+			calls++
+			// Without a registered user data type, handlers always get a string
+			require.Equal(t, "", userData)
+			return MetaItem{ID: id, Type: "series", Name: "Show", Videos: []VideoItem{{ID: id + ":1:2", Title: "Episode", Season: "1", Episode: "2"}}}, nil
+		},
+	})
+	app := addon.createApp()
+
+	for i := 0; i < 2; i++ {
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/series/custom:1:1:2.json", nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode)
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, `{"streams":[{"url":"http://example.com/1","title":"Show - Episode"}]}`, string(body))
+	}
+	// The second request must be served from the cache
+	require.Equal(t, 1, calls)
+
+	// Meta from the meta handlers can't be combined with a meta client
+	opts.MetaClient = staticMetaFetcher{}
+	_, err = NewAddon(manifest, nil, streamHandlers, opts)
+	require.Error(t, err)
+}
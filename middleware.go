@@ -1,6 +1,8 @@
 package stremio
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
@@ -42,22 +44,29 @@ func createLoggingMiddleware(logger *zap.Logger, logIPs, logUserAgent, logMediaN
 
 		// Then log
 
-		isStream := c.Locals("isStream") != nil
+		// Set by the meta middleware for requests for a movie or TV show
+		isMediaRequest := c.Locals(mediaRequestLocalsKey) != nil
 
 		// Get meta from context - the meta middleware put it there.
 		// We ignore ErrNoMeta here, because actual issues are logged by the meta middleware already, and here we'd have to check for things like "is config required but not set", "is the ID bad and the ID matcher was used" which are all valid cases to not have meta in the context.
 		var mediaName string
-		if logMediaName && isStream {
-			if meta, err := cinemeta.GetMetaFromContext(c.Context()); err != nil && err != cinemeta.ErrNoMeta {
+		if logMediaName && isMediaRequest {
+			if meta, err := GetMetaFromFiberCtx(c); err != nil && err != cinemeta.ErrNoMeta {
 				logger.Error("Couldn't get meta from context", zap.Error(err))
 			} else if err != cinemeta.ErrNoMeta {
 				mediaName = fmt.Sprintf("%v (%v)", meta.Name, meta.ReleaseInfo)
+				if meta.Episode != nil {
+					mediaName += fmt.Sprintf(" S%02dE%02d", meta.Episode.Season, meta.Episode.Episode)
+					if meta.Episode.Name != "" {
+						mediaName += " - " + meta.Episode.Name
+					}
+				}
 			}
 		}
 
 		var zapFields []zap.Field
 		// TODO: To increase performance, don't create a new slice for every request. Use sync.Pool.
-		if logMediaName && isStream {
+		if logMediaName && isMediaRequest {
 			zapFields = make([]zap.Field, zapFieldCount+1)
 		} else {
 			zapFields = make([]zap.Field, zapFieldCount)
@@ -81,7 +90,7 @@ func createLoggingMiddleware(logger *zap.Logger, logIPs, logUserAgent, logMediaN
 				zapFields[6] = zap.String("userAgent", c.Get(fiber.HeaderUserAgent))
 			}
 		}
-		if logMediaName && isStream {
+		if logMediaName && isMediaRequest {
 			if mediaName == "" {
 				mediaName = "?"
 			}
@@ -108,6 +117,8 @@ func createMetricsMiddleware() fiber.Handler {
 	manifestRegex := regexp.MustCompile("^/.*/manifest.json$")
 	catalogRegex := regexp.MustCompile(`^/.*/catalog/.*/.*\.json`)
 	streamRegex := regexp.MustCompile(`^/.*/stream/.*/.*\.json`)
+	metaRegex := regexp.MustCompile(`^/.*/meta/.*/.*\.json`)
+	subtitlesRegex := regexp.MustCompile(`^/.*/subtitles/.*/.*\.json`)
 
 	return func(c *fiber.Ctx) error {
 		if err := c.Next(); err != nil {
@@ -124,6 +135,8 @@ func createMetricsMiddleware() fiber.Handler {
 			endpoint = "manifest"
 		case "/configure":
 			endpoint = "configure"
+		case "/install":
+			endpoint = "install"
 		case "/health":
 			endpoint = "health"
 		case "/metrics":
@@ -135,6 +148,10 @@ func createMetricsMiddleware() fiber.Handler {
 				endpoint = "catalog"
 			} else if strings.HasPrefix(path, "/stream") {
 				endpoint = "stream"
+			} else if strings.HasPrefix(path, "/meta") {
+				endpoint = "meta"
+			} else if strings.HasPrefix(path, "/subtitles") {
+				endpoint = "subtitles"
 			} else if strings.HasPrefix(path, "/configure") {
 				endpoint = "configure-other"
 			} else if strings.HasPrefix(path, "/debug/pprof") {
@@ -149,6 +166,10 @@ func createMetricsMiddleware() fiber.Handler {
 				endpoint = "catalog-data"
 			} else if streamRegex.MatchString(path) {
 				endpoint = "stream-data"
+			} else if metaRegex.MatchString(path) {
+				endpoint = "meta-data"
+			} else if subtitlesRegex.MatchString(path) {
+				endpoint = "subtitles-data"
 			}
 		}
 
@@ -197,21 +218,34 @@ func corsMiddleware() fiber.Handler {
 
 func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDregexString string, logger *zap.Logger) {
 	streamIDregex := regexp.MustCompile(streamIDregexString)
+	// Used for catalog, meta and subtitles requests. Requests with extras (like "skip=100") have their own route, but are matched the same way.
+	typeAndIDMatcher := func(c *fiber.Ctx) error {
+		if c.Params("type", "") == "" || c.Params("id", "") == "" {
+			logger.Debug("Rejecting bad request due to missing type or ID")
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		c.Locals("isConfigured", true)
+		return c.Next()
+	}
 	if requiresUserData {
-		// Catalog
-		app.Use("/catalog/:type/:id.json", func(c *fiber.Ctx) error {
+		reject := func(c *fiber.Ctx) error {
 			// If user data is required but not sent, let clients know they sent a bad request.
 			// That's better than responding with 404, leading to clients thinking it's a server-side error.
 			return c.SendStatus(fiber.StatusBadRequest)
-		})
-		app.Use("/:userData/catalog/:type/:id.json", func(c *fiber.Ctx) error {
-			if c.Params("type", "") == "" || c.Params("id", "") == "" {
-				logger.Debug("Rejecting bad request due to missing type or ID")
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
-			c.Locals("isConfigured", true)
-			return c.Next()
-		})
+		}
+		// Catalog
+		app.Use("/catalog/:type/:id.json", reject)
+		app.Use("/catalog/:type/:id/:extra.json", reject)
+		app.Use("/:userData/catalog/:type/:id.json", typeAndIDMatcher)
+		app.Use("/:userData/catalog/:type/:id/:extra.json", typeAndIDMatcher)
+		// Meta
+		app.Use("/meta/:type/:id.json", reject)
+		app.Use("/:userData/meta/:type/:id.json", typeAndIDMatcher)
+		// Subtitles
+		app.Use("/subtitles/:type/:id.json", reject)
+		app.Use("/subtitles/:type/:id/:extra.json", reject)
+		app.Use("/:userData/subtitles/:type/:id.json", typeAndIDMatcher)
+		app.Use("/:userData/subtitles/:type/:id/:extra.json", typeAndIDMatcher)
 		// Stream
 		app.Use("/stream/:type/:id.json", func(c *fiber.Ctx) error {
 			return c.SendStatus(fiber.StatusBadRequest)
@@ -232,27 +266,22 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
 			c.Locals("isConfigured", true)
-			c.Locals("isStream", true)
 			return c.Next()
 		})
 	} else {
 		// Catalog
-		app.Use("/catalog/:type/:id.json", func(c *fiber.Ctx) error {
-			if c.Params("type", "") == "" || c.Params("id", "") == "" {
-				logger.Debug("Rejecting bad request due to missing type or ID")
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
-			c.Locals("isConfigured", true)
-			return c.Next()
-		})
-		app.Use("/:userData/catalog/:type/:id.json", func(c *fiber.Ctx) error {
-			if c.Params("type", "") == "" || c.Params("id", "") == "" {
-				logger.Debug("Rejecting bad request due to missing type or ID")
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
-			c.Locals("isConfigured", true)
-			return c.Next()
-		})
+		app.Use("/catalog/:type/:id.json", typeAndIDMatcher)
+		app.Use("/catalog/:type/:id/:extra.json", typeAndIDMatcher)
+		app.Use("/:userData/catalog/:type/:id.json", typeAndIDMatcher)
+		app.Use("/:userData/catalog/:type/:id/:extra.json", typeAndIDMatcher)
+		// Meta
+		app.Use("/meta/:type/:id.json", typeAndIDMatcher)
+		app.Use("/:userData/meta/:type/:id.json", typeAndIDMatcher)
+		// Subtitles
+		app.Use("/subtitles/:type/:id.json", typeAndIDMatcher)
+		app.Use("/subtitles/:type/:id/:extra.json", typeAndIDMatcher)
+		app.Use("/:userData/subtitles/:type/:id.json", typeAndIDMatcher)
+		app.Use("/:userData/subtitles/:type/:id/:extra.json", typeAndIDMatcher)
 		// Stream
 		app.Use("/stream/:type/:id.json", func(c *fiber.Ctx) error {
 			id := c.Params("id", "")
@@ -269,7 +298,6 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 				logger.Debug("Rejecting bad request due to stream ID not matching the given regex")
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
-			c.Locals("isStream", true)
 			return c.Next()
 		})
 		app.Use("/:userData/stream/:type/:id.json", func(c *fiber.Ctx) error {
@@ -288,29 +316,71 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
 			c.Locals("isConfigured", true)
-			c.Locals("isStream", true)
 			return c.Next()
 		})
 	}
 }
 
-func createMetaMiddleware(metaClient MetaFetcher, putMetaInHandlerContext, logMediaName bool, logger *zap.Logger) fiber.Handler {
+// metaLocalsKey is the key of the meta in the Fiber request locals.
+// It's namespaced, because Fiber locals can only have string keys.
+const metaLocalsKey = "github.com/deflix-tv/go-stremio/meta"
+
+// mediaRequestLocalsKey is the key of the Fiber request local that marks requests for a movie or TV show,
+// so that the logging middleware knows when to log the media name.
+const mediaRequestLocalsKey = "github.com/deflix-tv/go-stremio/isMediaRequest"
+
+// createMetaMiddleware creates the middleware that fetches the meta for requests of the given resource.
+// For stream and subtitles requests the ID is the one of the movie or TV show episode,
+// for meta requests the one of the movie or TV show.
+// For catalog requests it's the value of the catalogMetaExtra, and requests without it are passed on without meta.
+func createMetaMiddleware(metaClient MetaFetcher, putMetaInHandlerContext, logMediaName bool, resource, catalogMetaExtra string, logger *zap.Logger) fiber.Handler {
+	// Only stream and subtitles IDs contain the season and episode of TV shows
+	showLevelIDs := resource == "meta" || resource == "catalog"
 	return func(c *fiber.Ctx) error {
+		// type and id can never be empty, because that's been checked by a previous middleware.
+		// They must be read before calling `c.Next()`, because Fiber overwrites the route params when matching the next routes.
+		t := c.Params("type", "")
+		var id string
+		if resource == "catalog" {
+			// Invalid extras are rejected by the handler
+			extra, err := parseCatalogExtra(c.Params("extra", ""))
+			if err != nil {
+				return c.Next()
+			}
+			if id = extra.Values.Get(catalogMetaExtra); id == "" {
+				return c.Next()
+			}
+		} else {
+			var err error
+			if id, err = url.PathUnescape(c.Params("id", "")); err != nil {
+				logger.Error("ID in URL parameters couldn't be unescaped", zap.String("id", c.Params("id", "")))
+				return c.Next()
+			}
+		}
+		c.Locals(mediaRequestLocalsKey, true)
 		// If we should put the meta in the context for *handlers* we get the meta synchronously.
 		// Otherwise we only need it for logging and can get the meta asynchronously.
 		if putMetaInHandlerContext {
-			putMetaInContext(c, metaClient, logger)
+			if meta, ok := fetchMeta(c.Context(), t, id, showLevelIDs, metaClient, logger); ok {
+				c.Locals(metaLocalsKey, meta)
+			}
 			return c.Next()
 		} else if logMediaName {
+			var meta cinemeta.Meta
+			var ok bool
 			var wg sync.WaitGroup
 			wg.Add(1)
 			go func() {
-				putMetaInContext(c, metaClient, logger)
+				// Not the request context, because Fiber's context isn't safe for concurrent use
+				meta, ok = fetchMeta(context.Background(), t, id, showLevelIDs, metaClient, logger)
 				wg.Done()
 			}()
 			err := c.Next()
 			// Wait so that the meta is in the context when returning to the logging middleware
 			wg.Wait()
+			if ok {
+				c.Locals(metaLocalsKey, meta)
+			}
 			return err
 		} else {
 			return c.Next()
@@ -318,48 +388,61 @@ func createMetaMiddleware(metaClient MetaFetcher, putMetaInHandlerContext, logMe
 	}
 }
 
-func putMetaInContext(c *fiber.Ctx, metaClient MetaFetcher, logger *zap.Logger) {
+// fetchMeta fetches the meta for the type and ID of the request. The boolean return value is false if that wasn't possible.
+// If showLevelID is true, IDs of TV shows don't contain a season and episode, like in meta requests.
+func fetchMeta(ctx context.Context, t, id string, showLevelID bool, metaClient MetaFetcher, logger *zap.Logger) (cinemeta.Meta, bool) {
 	var meta cinemeta.Meta
-	var err error
-	// type and id can never be empty, because that's been checked by a previous middleware
-	t := c.Params("type", "")
-	id := c.Params("id", "")
-	id, err = url.PathUnescape(id)
+	// Movie IDs are parsed without season and episode
+	parseType := t
+	if showLevelID {
+		parseType = "movie"
+	}
+	mediaID, err := ParseMediaID(parseType, id)
 	if err != nil {
-		logger.Error("ID in URL parameters couldn't be unescaped", zap.String("id", id))
-		return
+		logger.Warn("Couldn't parse ID", zap.Error(err), zap.String("id", id))
+		return meta, false
 	}
 
 	switch t {
 	case "movie":
-		meta, err = metaClient.GetMovie(c.Context(), id)
-		if err != nil {
-			logger.Error("Couldn't get movie info with MetaFetcher", zap.Error(err))
-			return
-		}
+		meta, err = metaClient.GetMovie(ctx, mediaID.ID)
 	case "series":
-		splitID := strings.Split(id, ":")
-		if len(splitID) != 3 {
-			logger.Warn("No 3 elements after splitting TV show ID by \":\"", zap.String("id", id))
-			return
-		}
-		season, err := strconv.Atoi(splitID[1])
-		if err != nil {
-			logger.Warn("Can't parse season as int", zap.String("season", splitID[1]))
-			return
-		}
-		episode, err := strconv.Atoi(splitID[2])
-		if err != nil {
-			logger.Warn("Can't parse episode as int", zap.String("episode", splitID[2]))
-			return
-		}
-		meta, err = metaClient.GetTVShow(c.Context(), splitID[0], season, episode)
-		if err != nil {
-			logger.Error("Couldn't get TV show info with MetaFetcher", zap.Error(err))
-			return
-		}
+		meta, err = metaClient.GetTVShow(ctx, mediaID.ID, mediaID.Season, mediaID.Episode)
+	default:
+		return meta, false
+	}
+	if errors.Is(err, ErrUnsupportedIDScheme) {
+		// Not worth more than a debug log, because addons can serve IDs of schemes that their MetaFetcher doesn't support
+		logger.Debug("No MetaFetcher for ID scheme", zap.String("id", id))
+		return meta, false
+	} else if errors.Is(err, cinemeta.ErrNotFound) {
+		// Addons can serve IDs that their MetaFetcher doesn't know, and it's the same for every request for the ID
+		logger.Debug("MetaFetcher doesn't know the ID", zap.String("id", id))
+		return meta, false
+	} else if errors.Is(err, cinemeta.ErrCircuitOpen) {
+		// The failures that opened the circuit breaker were logged already, logging each request would flood the logs
+		logger.Debug("Not getting "+t+" info, because the MetaFetcher's circuit breaker is open", zap.String("id", id))
+		return meta, false
+	} else if err != nil {
+		logger.Error("Couldn't get "+t+" info with MetaFetcher", zap.Error(err))
+		return meta, false
 	}
 
 	logger.Debug("Got meta from cinemata client", zap.String("meta", fmt.Sprintf("%+v", meta)))
-	c.Locals("meta", meta)
+	return meta, true
+}
+
+// GetMetaFromFiberCtx returns the Meta object that go-stremio put into the request if PutMetaInContext or LogMediaName are set in the options.
+// It's meant for custom middlewares and endpoints. Handlers like the StreamHandler get the meta in their context instead, see `cinemeta.GetMetaFromContext()`.
+// It returns cinemeta.ErrNoMeta if the request doesn't contain meta.
+// Note that with only LogMediaName being set, the meta is fetched asynchronously and only available after calling `c.Next()`.
+func GetMetaFromFiberCtx(c *fiber.Ctx) (cinemeta.Meta, error) {
+	metaIface := c.Locals(metaLocalsKey)
+	if metaIface == nil {
+		return cinemeta.Meta{}, cinemeta.ErrNoMeta
+	} else if meta, ok := metaIface.(cinemeta.Meta); ok {
+		return meta, nil
+	} else {
+		return cinemeta.Meta{}, fmt.Errorf("couldn't turn meta interface value to proper object: type is %T", metaIface)
+	}
 }
@@ -0,0 +1,92 @@
+package stremio
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Notice is an error that handlers can return to show the user a message instead of results,
+// for example that their subscription expired or that their configuration is invalid.
+// Stream responses contain it as a single stream with the message as title, which opens the notice's link when clicked.
+// Catalog responses contain it as a single item with the message as name.
+// Meta responses contain it as meta of the requested item with the message as name and the link as website.
+// Subtitles responses are empty, because Stremio has no way to show a message there.
+// The response has the status "200 OK", because Stremio doesn't show the bodies of error responses.
+// It can be wrapped, for example with `fmt.Errorf("...: %w", notice)`, and is still detected.
+type Notice struct {
+	// Short message for the user, e.g. "Your subscription expired".
+	Message string
+	// Optional details, shown below the message.
+	Description string
+	// Optional URL that's opened when the user clicks the notice.
+	// If empty, the NoticeURL from the options is used, or the addon's configure page with the user's data if it has one.
+	URL string
+}
+
+// NewNotice creates a new Notice with the given message for the user.
+func NewNotice(message string) *Notice {
+	return &Notice{
+		Message: message,
+	}
+}
+
+// Error returns the message and description.
+func (n *Notice) Error() string {
+	if n.Description != "" {
+		return "notice: " + n.Message + ": " + n.Description
+	}
+	return "notice: " + n.Message
+}
+
+// noticeURL returns the URL for the notice.
+// The configure URL is only used if the addon has a configure page.
+func noticeURL(notice *Notice, defaultURL, baseURL, userData string, hasConfigurePage bool) string {
+	if notice.URL != "" {
+		return notice.URL
+	} else if defaultURL != "" {
+		return defaultURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if !hasConfigurePage {
+		return baseURL + "/"
+	} else if userData != "" {
+		return baseURL + "/" + userData + "/configure"
+	}
+	return baseURL + "/configure"
+}
+
+// renderNotice returns the response body for the notice, depending on the handler's JSON key ("streams", "metas", "meta" or "subtitles").
+func renderNotice(notice *Notice, jsonKey []byte, requestedType, requestedID, url string) ([]byte, error) {
+	var items interface{}
+	switch string(jsonKey) {
+	case "streams":
+		title := notice.Message
+		if notice.Description != "" {
+			title += "\n" + notice.Description
+		}
+		items = []StreamItem{{
+			ExternalURL: url,
+			Title:       title,
+		}}
+	case "meta":
+		items = MetaItem{
+			ID:          requestedID,
+			Type:        requestedType,
+			Name:        notice.Message,
+			Description: notice.Description,
+			Website:     url,
+		}
+	case "subtitles":
+		items = []SubtitleItem{}
+	default:
+		items = []MetaPreviewItem{{
+			ID:          "notice",
+			Type:        requestedType,
+			Name:        notice.Message,
+			Description: notice.Description,
+		}}
+	}
+	return json.Marshal(map[string]interface{}{
+		string(jsonKey): items,
+	})
+}
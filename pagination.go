@@ -0,0 +1,103 @@
+package stremio
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// CatalogPageSize is the number of items per catalog page that Stremio expects.
+// When a response contains a full page, Stremio requests the next page with the "skip" extra set to the number of items it already has.
+const CatalogPageSize = 100
+
+// CatalogExtra contains the extra parameters of a catalog request, like Stremio's "skip" for requesting the next page.
+// Stremio only sends the extras that a catalog declares in the manifest, so for pagination the catalog needs an ExtraItem with the name "skip".
+type CatalogExtra struct {
+	// Number of items that Stremio already has. 0 for the first page.
+	Skip int
+	// Genre that the catalog should be filtered by. Empty if none was selected.
+	Genre string
+	// Search query. Empty if it's not a search request.
+	Search string
+	// All extra parameters as in the request, including the ones above, for example for custom extras.
+	Values url.Values
+}
+
+// catalogExtraContextKey is the type of the context key for the catalog extras.
+// It's unexported, so it can't collide with context keys of other packages.
+type catalogExtraContextKey struct{}
+
+// GetCatalogExtra returns the extra parameters of the catalog request from the CatalogHandler's context.
+// For requests without extras it returns a CatalogExtra with zero values.
+func GetCatalogExtra(ctx context.Context) CatalogExtra {
+	extra, _ := ctx.Value(catalogExtraContextKey{}).(CatalogExtra)
+	return extra
+}
+
+// parseCatalogExtra parses the extra parameters of a catalog request, like "genre=Action&skip=100".
+func parseCatalogExtra(extraString string) (CatalogExtra, error) {
+	values, err := url.ParseQuery(extraString)
+	if err != nil {
+		return CatalogExtra{}, fmt.Errorf("Couldn't parse extra: %w", err)
+	}
+	extra := CatalogExtra{
+		Genre:  values.Get("genre"),
+		Search: values.Get("search"),
+		Values: values,
+	}
+	if skip := values.Get("skip"); skip != "" {
+		if extra.Skip, err = strconv.Atoi(skip); err != nil || extra.Skip < 0 {
+			return CatalogExtra{}, fmt.Errorf("Invalid skip %q", skip)
+		}
+	}
+	return extra, nil
+}
+
+// CatalogLoader loads up to limit catalog items starting at the offset, for example from a database.
+type CatalogLoader func(ctx context.Context, offset, limit int) ([]MetaPreviewItem, error)
+
+// PaginateCatalog returns the page of the items that starts at skip, with up to CatalogPageSize items.
+// In a CatalogHandler the skip of the request is `GetCatalogExtra(ctx).Skip`.
+// The boolean return value signals if there are more items after the page.
+// The page is a copy, so that modifying it (for example via EnrichCatalogs or after hooks) doesn't modify the items.
+// A negative skip is treated as 0, a skip beyond the items leads to an empty page.
+func PaginateCatalog(items []MetaPreviewItem, skip int) ([]MetaPreviewItem, bool) {
+	start, end := pageBounds(len(items), skip)
+	page := make([]MetaPreviewItem, end-start)
+	copy(page, items[start:end])
+	return page, end < len(items)
+}
+
+// PaginateCatalogLazy is like PaginateCatalog, but loads only the items of the page with the loader.
+// The total is the number of all items. The loader isn't called if the page is empty.
+func PaginateCatalogLazy(ctx context.Context, load CatalogLoader, total, skip int) ([]MetaPreviewItem, bool, error) {
+	start, end := pageBounds(total, skip)
+	if start == end {
+		return []MetaPreviewItem{}, false, nil
+	}
+	page, err := load(ctx, start, end-start)
+	if err != nil {
+		return nil, false, fmt.Errorf("Couldn't load catalog page: %w", err)
+	}
+	// Don't trust the loader to respect the limit, a page with more items would lead to skipped items in Stremio
+	if len(page) > end-start {
+		page = page[:end-start]
+	}
+	return page, end < total, nil
+}
+
+// pageBounds returns the start and end index of the page that starts at skip.
+func pageBounds(total, skip int) (int, int) {
+	if skip < 0 {
+		skip = 0
+	}
+	if skip > total {
+		skip = total
+	}
+	end := skip + CatalogPageSize
+	if end > total {
+		end = total
+	}
+	return skip, end
+}
@@ -0,0 +1,106 @@
+package stremio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPaginateCatalog(t *testing.T) {
+	items := make([]MetaPreviewItem, 250)
+	for i := range items {
+		items[i].ID = "tt" + strconv.Itoa(i)
+	}
+
+	page, hasMore := PaginateCatalog(items, 0)
+	require.Len(t, page, 100)
+	require.True(t, hasMore)
+	page, hasMore = PaginateCatalog(items, 200)
+	require.Len(t, page, 50)
+	require.Equal(t, "tt200", page[0].ID)
+	require.False(t, hasMore)
+	page, _ = PaginateCatalog(items, 300)
+	require.Empty(t, page)
+
+	// The page must be a copy
+	page, _ = PaginateCatalog(items, 0)
+	page[0].Name = "foo"
+	require.Equal(t, "", items[0].Name)
+
+	var calls int
+	load := func(_ context.Context, offset, limit int) ([]MetaPreviewItem, error) {
+		calls++
+		return items[offset : offset+limit], nil
+	}
+	page, hasMore, err := PaginateCatalogLazy(context.Background(), load, len(items), 100)
+	require.NoError(t, err)
+	require.Len(t, page, 100)
+	require.Equal(t, "tt100", page[0].ID)
+	require.True(t, hasMore)
+	page, hasMore, err = PaginateCatalogLazy(context.Background(), load, len(items), 250)
+	require.NoError(t, err)
+	require.Empty(t, page)
+	require.False(t, hasMore)
+	require.Equal(t, 1, calls)
+}
+
+func TestCatalogPaginationRequests(t *testing.T) {
+	items := make([]MetaPreviewItem, 250)
+	for i := range items {
+		items[i] = MetaPreviewItem{ID: "tt" + strconv.Itoa(i), Type: "movie", Name: "Movie " + strconv.Itoa(i)}
+	}
+	var genre string
+	catalogHandlers := map[string]CatalogHandler{
+		"movie": func(ctx context.Context, id string, _ interface{}) ([]MetaPreviewItem, error) {
+			extra := GetCatalogExtra(ctx)
+			genre = extra.Genre
+			page, _ := PaginateCatalog(items, extra.Skip)
+			return page, nil
+		},
+	}
+	manifest := Manifest{
+		ID:          "com.example.test",
+		Name:        "Test",
+		Description: "Test",
+		Version:     "0.1.0",
+		Catalogs:    []CatalogItem{{Type: "movie", ID: "top", Name: "Top", Extra: []ExtraItem{{Name: "genre"}, {Name: "skip"}}}},
+	}
+	addon, err := NewAddon(manifest, catalogHandlers, nil, Options{Logger: zap.NewNop(), CacheAgeCatalogs: time.Hour})
+	require.NoError(t, err)
+	app := addon.createApp()
+
+	for _, test := range []struct {
+		path      string
+		firstID   string
+		pageLen   int
+		wantGenre string
+	}{
+		{"/catalog/movie/top.json", "tt0", 100, ""},
+		{"/catalog/movie/top/skip=100.json", "tt100", 100, ""},
+		{"/catalog/movie/top/genre=Sci-Fi&skip=200.json", "tt200", 50, "Sci-Fi"},
+		{"/foo/catalog/movie/top/skip=100.json", "tt100", 100, ""},
+	} {
+		res, err := app.Test(httptest.NewRequest("GET", test.path, nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, res.StatusCode, test.path)
+		// Each page is cached on its own, because each has its own URL
+		require.Equal(t, "max-age=3600, private", res.Header.Get("Cache-Control"), test.path)
+		var body struct {
+			Metas []MetaPreviewItem `json:"metas"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		require.Len(t, body.Metas, test.pageLen, test.path)
+		require.Equal(t, test.firstID, body.Metas[0].ID, test.path)
+		require.Equal(t, test.wantGenre, genre, test.path)
+	}
+
+	res, err := app.Test(httptest.NewRequest("GET", "/catalog/movie/top/skip=foo.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, 400, res.StatusCode)
+}
@@ -0,0 +1,140 @@
+package cinemeta
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// ErrCircuitOpen signals that the client didn't send a request to Cinemeta, because previous requests failed repeatedly.
+// The client returns it wrapped, so use `errors.Is()` to check for it.
+var ErrCircuitOpen = errors.New("circuit breaker for Cinemeta is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// openBreakers is the number of circuit breakers (of all clients) that currently aren't closed.
+var openBreakers int64
+
+func init() {
+	metrics.NewGauge("cinemeta_circuit_breaker_open", func() float64 {
+		return float64(atomic.LoadInt64(&openBreakers))
+	})
+}
+
+// circuitBreaker stops requests to Cinemeta after repeated failures.
+// After the cooldown it lets a single probe request through ("half-open"), which either closes the breaker or opens it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	lock     *sync.Mutex
+}
+
+// newCircuitBreaker creates a new circuitBreaker. A threshold below 1 disables it.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		lock:      &sync.Mutex{},
+	}
+}
+
+// allow returns true if a request may be sent.
+// In the half-open state only the first caller gets true, until the probe's result is recorded.
+func (b *circuitBreaker) allow() bool {
+	if b.threshold < 1 {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			metrics.GetOrCreateCounter("cinemeta_circuit_breaker_rejections_total").Inc()
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		metrics.GetOrCreateCounter("cinemeta_circuit_breaker_rejections_total").Inc()
+		return false
+	default:
+		return true
+	}
+}
+
+// success records a successful request, which closes the breaker.
+func (b *circuitBreaker) success() {
+	if b.threshold < 1 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = 0
+	b.setState(breakerClosed)
+}
+
+// failure records a failed request, which opens the breaker when the threshold is reached or when the probe failed.
+func (b *circuitBreaker) failure() {
+	if b.threshold < 1 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// abort records a request without result, for example because it was canceled by the caller.
+// If it was the probe, the next request can be the probe instead.
+func (b *circuitBreaker) abort() {
+	if b.threshold < 1 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == breakerHalfOpen {
+		b.openedAt = time.Now().Add(-b.cooldown)
+		b.setState(breakerOpen)
+	}
+}
+
+// setState changes the state and updates the metrics. The lock must be held by the caller.
+func (b *circuitBreaker) setState(state breakerState) {
+	if state == b.state {
+		return
+	}
+	if b.state == breakerClosed {
+		atomic.AddInt64(&openBreakers, 1)
+	} else if state == breakerClosed {
+		atomic.AddInt64(&openBreakers, -1)
+	}
+	b.state = state
+	metrics.GetOrCreateCounter(`cinemeta_circuit_breaker_transitions_total{state="` + state.String() + `"}`).Inc()
+}
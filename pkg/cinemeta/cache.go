@@ -25,7 +25,8 @@ type Cache interface {
 var _ Cache = (*InMemoryCache)(nil)
 
 // InMemoryCache is an example implementation of the Cache interface.
-// It doesn't persist its data, so it's not suited for production use of the cinemeta package.
+// It doesn't persist its data and grows without bounds, so it's not suited for production use of the cinemeta package.
+// For a size-bounded in-memory cache use the LRUCache, which implements CacheV2.
 type InMemoryCache struct {
 	cache map[string]CacheItem
 	lock  *sync.RWMutex
@@ -58,3 +59,11 @@ func (c *InMemoryCache) Get(key string) (Meta, time.Time, bool, error) {
 	cacheItem, found := c.cache[key]
 	return cacheItem.Meta, cacheItem.Created, found, nil
 }
+
+// Delete removes the item from the cache.
+func (c *InMemoryCache) Delete(key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.cache, key)
+	return nil
+}
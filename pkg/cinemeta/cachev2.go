@@ -0,0 +1,84 @@
+package cinemeta
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// CacheV2 is the second version of the interface that the cinemeta client uses for caching meta.
+// Compared to Cache it has a context for network-backed caches, a TTL per item and a Delete method.
+// Implementations should also implement CacheStatsProvider if they can.
+// Existing Cache implementations can be used via AdaptCache.
+type CacheV2 interface {
+	// Get returns a meta object and the time it was cached.
+	// The boolean return value signals if the value was found in the cache.
+	Get(ctx context.Context, key string) (Meta, time.Time, bool, error)
+	// Set stores a meta object and the current time in the cache.
+	// The cache may evict the item after the TTL, but it doesn't have to. A TTL of 0 means the cache's default expiry, which can be no expiry at all.
+	// The client checks the age of items itself, so a cache must never return an item as older or younger than it is.
+	Set(ctx context.Context, key string, meta Meta, ttl time.Duration) error
+	// Delete removes the item from the cache. Deleting a non-existing item is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// CacheStats are statistics of a cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Items     int
+}
+
+// CacheStatsProvider is an optional interface for caches (both Cache and CacheV2) that can report statistics.
+type CacheStatsProvider interface {
+	Stats(ctx context.Context) (CacheStats, error)
+}
+
+// ErrDeleteNotSupported is returned by the Delete method of an adapted Cache that doesn't have a Delete method itself.
+var ErrDeleteNotSupported = errors.New("the cache doesn't support deleting items")
+
+// ErrStatsNotSupported is returned by the Stats method of an adapted Cache or the client when the cache doesn't implement CacheStatsProvider.
+var ErrStatsNotSupported = errors.New("the cache doesn't support statistics")
+
+var (
+	_ CacheV2            = (*cacheAdapter)(nil)
+	_ CacheStatsProvider = (*cacheAdapter)(nil)
+)
+
+// cacheAdapter makes a Cache usable as CacheV2.
+type cacheAdapter struct {
+	cache Cache
+}
+
+// AdaptCache wraps a Cache so it can be used as CacheV2.
+// The context and TTL are ignored, because the client checks the age of items itself.
+// Delete works if the Cache has a `Delete(key string) error` method, otherwise it returns ErrDeleteNotSupported.
+// Stats works if the Cache implements CacheStatsProvider, otherwise it returns ErrStatsNotSupported.
+func AdaptCache(cache Cache) CacheV2 {
+	return &cacheAdapter{
+		cache: cache,
+	}
+}
+
+func (a *cacheAdapter) Get(_ context.Context, key string) (Meta, time.Time, bool, error) {
+	return a.cache.Get(key)
+}
+
+func (a *cacheAdapter) Set(_ context.Context, key string, meta Meta, _ time.Duration) error {
+	return a.cache.Set(key, meta)
+}
+
+func (a *cacheAdapter) Delete(_ context.Context, key string) error {
+	if deleter, ok := a.cache.(interface{ Delete(key string) error }); ok {
+		return deleter.Delete(key)
+	}
+	return ErrDeleteNotSupported
+}
+
+func (a *cacheAdapter) Stats(ctx context.Context) (CacheStats, error) {
+	if statsProvider, ok := a.cache.(CacheStatsProvider); ok {
+		return statsProvider.Stats(ctx)
+	}
+	return CacheStats{}, ErrStatsNotSupported
+}
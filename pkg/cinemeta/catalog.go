@@ -0,0 +1,193 @@
+package cinemeta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MetaPreview represents a movie or TV show in a Cinemeta catalog.
+// It contains less info than Meta, which you can get via the client's GetMovie and GetTVShow methods.
+type MetaPreview struct {
+	ID   string `json:"id"`   // IMDb ID, e.g. "tt0133093"
+	Type string `json:"type"` // "movie" or "series"
+	Name string `json:"name"`
+
+	// Optional
+	Poster      string   `json:"poster,omitempty"`
+	PosterShape string   `json:"posterShape,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+	IMDbRating  string   `json:"imdbRating,omitempty"`
+	ReleaseInfo string   `json:"releaseInfo,omitempty"` // A.k.a. *year*. E.g. "2000" for movies and "2000-2014" or "2000-" for TV shows
+	Description string   `json:"description,omitempty"`
+}
+
+type cinemetaCatalogResponse struct {
+	Metas []MetaPreview `json:"metas"`
+}
+
+// CatalogCache is the interface that the cinemeta client uses for caching catalogs and search results.
+// It's the equivalent of CacheV2 for lists of MetaPreview instead of single Meta objects,
+// with a context for network-backed caches and a TTL per item.
+// Implementations should also implement CacheStatsProvider if they can.
+// An implementation is the LRUCatalogCache in this package.
+type CatalogCache interface {
+	// Get returns the meta previews and the time they were cached.
+	// The boolean return value signals if the value was found in the cache.
+	Get(ctx context.Context, key string) ([]MetaPreview, time.Time, bool, error)
+	// Set stores the meta previews and the current time in the cache.
+	// The cache may evict the item after the TTL, but it doesn't have to. A TTL of 0 means the cache's default expiry, which can be no expiry at all.
+	// The client checks the age of items itself, so a cache must never return an item as older or younger than it is.
+	Set(ctx context.Context, key string, metas []MetaPreview, ttl time.Duration) error
+}
+
+var (
+	_ CatalogCache       = (*LRUCatalogCache)(nil)
+	_ CacheStatsProvider = (*LRUCatalogCache)(nil)
+)
+
+// LRUCatalogCache is a size-bounded implementation of the CatalogCache interface, which works like the LRUCache.
+// Each search query is a new item, so a bound is important for catalog caches.
+// It stores and returns copies of the meta previews, so callers can modify them.
+// Its metrics are named "cinemeta_catalog_cache_*" instead of "cinemeta_cache_*".
+// It doesn't persist its data.
+type LRUCatalogCache struct {
+	list *lruList
+}
+
+// NewLRUCatalogCache creates a new LRUCatalogCache.
+// The capacity is the maximum number of catalogs and search results and must be greater than 0.
+// The max age is used as expiry for items that are set with a TTL of 0, while the client sets items with its CatalogTTL.
+// A max age of 0 means that those items don't expire and are only evicted when the cache is full.
+func NewLRUCatalogCache(capacity int, maxAge time.Duration) *LRUCatalogCache {
+	return &LRUCatalogCache{
+		list: newLRUList(capacity, maxAge, "cinemeta_catalog_cache"),
+	}
+}
+
+// Set stores a copy of the meta previews and the current time in the cache.
+// The item expires after the TTL, or after the cache's max age if the TTL is 0.
+// If the cache is full, the least recently used item is evicted.
+func (c *LRUCatalogCache) Set(_ context.Context, key string, metas []MetaPreview, ttl time.Duration) error {
+	c.list.set(key, copyMetaPreviews(metas), ttl)
+	return nil
+}
+
+// Get returns a copy of the meta previews and the time they were cached from the cache.
+// The boolean return value signals if the value was found in the cache.
+// Expired items are evicted and not returned.
+func (c *LRUCatalogCache) Get(_ context.Context, key string) ([]MetaPreview, time.Time, bool, error) {
+	value, created, found := c.list.get(key)
+	if !found {
+		return nil, time.Time{}, false, nil
+	}
+	return copyMetaPreviews(value.([]MetaPreview)), created, true, nil
+}
+
+// Stats returns the statistics of this cache.
+func (c *LRUCatalogCache) Stats(_ context.Context) (CacheStats, error) {
+	return c.list.getStats(), nil
+}
+
+// copyMetaPreviews returns a deep copy of the meta previews.
+func copyMetaPreviews(metas []MetaPreview) []MetaPreview {
+	if metas == nil {
+		return nil
+	}
+	metasCopy := make([]MetaPreview, len(metas))
+	for i, meta := range metas {
+		if meta.Genres != nil {
+			meta.Genres = append(make([]string, 0, len(meta.Genres)), meta.Genres...)
+		}
+		metasCopy[i] = meta
+	}
+	return metasCopy
+}
+
+// Catalog returns the items of one of Cinemeta's catalogs, either from the catalog cache or from Cinemeta.
+// The type must be "movie" or "series". Cinemeta's catalog IDs are for example "top", "year" and "imdbRating".
+// The extras can contain for example a "genre" and "skip" for paging, like in Stremio's catalog requests. They can be nil.
+// Catalog results are only cached if the client options contain a CatalogCache.
+func (c *Client) Catalog(ctx context.Context, t, id string, extras map[string]string) ([]MetaPreview, error) {
+	if t != "movie" && t != "series" {
+		return nil, fmt.Errorf("Invalid type %q, only \"movie\" and \"series\" are supported", t)
+	} else if id == "" {
+		return nil, errors.New("Catalog ID is empty")
+	}
+
+	path := "/catalog/" + t + "/" + url.PathEscape(id)
+	if extra := encodeExtras(extras); extra != "" {
+		path += "/" + extra
+	}
+	path += ".json"
+	zapFieldPath := zap.String("path", path)
+
+	// Check cache first
+	if c.catalogCache != nil {
+		metas, created, found, err := c.catalogCache.Get(ctx, path)
+		if err != nil {
+			c.logger.Error("Couldn't get catalog from cache", zap.Error(err), zapFieldPath)
+		} else if !found {
+			c.logger.Debug("Catalog not found in cache", zapFieldPath)
+		} else if time.Since(created) > c.catalogTTL {
+			c.logger.Debug("Hit cache for catalog, but item is expired", zap.Duration("expiredSince", time.Since(created)-c.catalogTTL), zapFieldPath)
+		} else {
+			c.logger.Debug("Hit cache for catalog, returning result", zapFieldPath)
+			return metas, nil
+		}
+	}
+
+	// Then check web service
+	resBody, err := c.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	cineRes := cinemetaCatalogResponse{}
+	if err := json.Unmarshal(resBody, &cineRes); err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal response body: %v", err)
+	}
+
+	// Fill cache
+	if c.catalogCache != nil {
+		if err := c.catalogCache.Set(ctx, path, cineRes.Metas, c.catalogTTL); err != nil {
+			c.logger.Error("Couldn't cache catalog", zap.Error(err), zapFieldPath)
+		}
+	}
+
+	return cineRes.Metas, nil
+}
+
+// Search returns the movies or TV shows that match the query, either from the catalog cache or from Cinemeta.
+// The type must be "movie" or "series".
+// It's useful for matching titles to IMDb IDs. The results are ordered by relevance.
+func (c *Client) Search(ctx context.Context, t, query string) ([]MetaPreview, error) {
+	if query == "" {
+		return nil, errors.New("Search query is empty")
+	}
+	return c.Catalog(ctx, t, "top", map[string]string{"search": query})
+}
+
+// encodeExtras encodes the extras like Stremio does for the "extra" path segment, e.g. "genre=Action&skip=100".
+// The keys are sorted, so that the result can be used as cache key.
+func encodeExtras(extras map[string]string) string {
+	keys := make([]string, 0, len(extras))
+	for key := range extras {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		// Stremio encodes spaces as "%20", while `url.QueryEscape()` encodes them as "+"
+		value := strings.ReplaceAll(url.QueryEscape(extras[key]), "+", "%20")
+		parts = append(parts, url.QueryEscape(key)+"="+value)
+	}
+	return strings.Join(parts, "&")
+}
@@ -3,11 +3,15 @@ package cinemeta
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -17,63 +21,201 @@ type ClientOptions struct {
 	// The base URL for Cinemeta.
 	// Default "https://v3-cinemeta.strem.io".
 	BaseURL string
-	// Timeout for requests.
+	// Timeout for requests, including all retries and the backoff between them.
+	// The HTTP client that the Cinemeta client creates uses it as timeout for single attempts as well.
 	// A more customizable cancellation can be achieved with the context,
 	// but it can never be *longer* than this timeout.
-	// Default 2 seconds.
+	// Default 2 seconds, or the timeout of the HTTPClient if one is set.
 	Timeout time.Duration
+	// HTTP client for requests to Cinemeta, for example with a proxy or custom connection limits.
+	// When set, the Transport option isn't used, so configure it in the HTTP client.
+	// Its timeout applies to single attempts, while the Timeout option applies to all attempts together.
+	// Default nil (a new HTTP client with the Timeout and Transport options).
+	HTTPClient *http.Client
+	// Transport for the HTTP client that the Cinemeta client creates, for example for tracing or tests.
+	// Not used when setting an HTTPClient.
+	// Default nil (http.DefaultTransport).
+	Transport http.RoundTripper
+	// Functions that are called for each request to Cinemeta before sending it, including retries.
+	// They can modify the request, for example to set a User-Agent header identifying your addon.
+	// Default nil.
+	RequestDecorators []func(*http.Request)
+	// Cache for the results of the Catalog and Search methods, for example an LRUCatalogCache.
+	// Default nil (no caching of catalogs).
+	CatalogCache CatalogCache
+	// Max age of catalogs and search results in the catalog cache. The client sets items with it as TTL.
+	// Catalogs change more often than meta, so this should be much shorter than the TTL.
+	// Default 24 hours.
+	CatalogTTL time.Duration
 	// Max age of items in the cache.
 	// Default 30 days.
 	TTL time.Duration
+	// Max age of "not found" results in the cache.
+	// When Cinemeta responds with 404 or without a name, the client caches that,
+	// so that requests for unknown IDs don't hit Cinemeta every time.
+	// A negative value disables negative caching.
+	// Default 1 hour.
+	NegativeTTL time.Duration
+	// Duration after the TTL in which expired items are still returned, while they're refreshed in the background.
+	// This prevents requests from being blocked by Cinemeta requests for items that are only slightly expired.
+	// Default 0 (disabled).
+	StaleWhileRevalidate time.Duration
+	// Duration after the TTL in which expired items are still returned when refreshing them fails,
+	// so that Cinemeta outages don't lead to missing metadata.
+	// Default 0 (disabled).
+	// Note: With both stale options the cache must keep items for the TTL plus the larger stale duration.
+	// The client sets items with that TTL, which caches like the LRUCache use as expiry.
+	// Caches that are adapted with AdaptCache don't get the TTL, so their max age must be at least that long.
+	StaleIfError time.Duration
+	// Number of retries for failed requests.
+	// Only connection errors, timeouts and 5xx and 429 responses are retried.
+	// 0 leads to the default, so use a negative value to disable retries.
+	// Default 2.
+	MaxRetries int
+	// Base duration of the exponential backoff between retries. The backoff is capped at 30 seconds.
+	// The actual duration is randomized between half and the full value, to prevent multiple requests from retrying in lockstep.
+	// Default 100 milliseconds.
+	RetryBackoff time.Duration
+	// Number of consecutive failed requests (after their retries) after which the circuit breaker opens.
+	// While open, the client fails fast with ErrCircuitOpen instead of sending requests to Cinemeta.
+	// A negative value disables the circuit breaker.
+	// Default 5.
+	BreakerThreshold int
+	// Duration after which an open circuit breaker lets a single probe request through.
+	// If it succeeds, the breaker closes, otherwise it stays open for another cooldown.
+	// Default 30 seconds.
+	BreakerCooldown time.Duration
 }
 
 // DefaultClientOpts is an options object with sensible defaults.
 var DefaultClientOpts = ClientOptions{
 	BaseURL: "https://v3-cinemeta.strem.io",
 	// HTTP client timeout
-	Timeout: 2 * time.Second,
-	TTL:     30 * 24 * time.Hour, // 30 days
+	Timeout:          2 * time.Second,
+	TTL:              30 * 24 * time.Hour, // 30 days
+	NegativeTTL:      time.Hour,
+	MaxRetries:       2,
+	RetryBackoff:     100 * time.Millisecond,
+	BreakerThreshold: 5,
+	BreakerCooldown:  30 * time.Second,
+	CatalogTTL:       24 * time.Hour,
 }
 
+// ErrNotFound signals that Cinemeta doesn't know the movie or TV show.
+// The client returns it wrapped, so use `errors.Is()` to check for it.
+var ErrNotFound = errors.New("meta not found in Cinemeta")
+
 // Client is the Cinemeta client.
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
-	cache      Cache
-	logger     *zap.Logger
-	ttl        time.Duration
+	baseURL              string
+	httpClient           *http.Client
+	timeout              time.Duration
+	cache                CacheV2
+	logger               *zap.Logger
+	ttl                  time.Duration
+	negativeTTL          time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	maxRetries           int
+	retryBackoff         time.Duration
+	breaker              *circuitBreaker
+	requestDecorators    []func(*http.Request)
+	catalogCache         CatalogCache
+	catalogTTL           time.Duration
+
+	// Cache keys of items that are currently refreshed in the background
+	refreshing     map[string]struct{}
+	refreshingLock *sync.Mutex
 }
 
 // NewClient creates a new Cinemeta client.
+// The cache is adapted to the CacheV2 interface with AdaptCache, so it doesn't get the TTL of items.
+// Caches that implement CacheV2, like the LRUCache, can be used with NewClientV2.
 func NewClient(opts ClientOptions, cache Cache, logger *zap.Logger) *Client {
+	return NewClientV2(opts, AdaptCache(cache), logger)
+}
+
+// NewClientV2 creates a new Cinemeta client that uses a CacheV2.
+func NewClientV2(opts ClientOptions, cache CacheV2, logger *zap.Logger) *Client {
 	// Set defaults if necessary.
 	// A TTL of 0 is allowed.
 	if opts.BaseURL == "" {
 		opts.BaseURL = DefaultClientOpts.BaseURL
 	}
 	if opts.Timeout == 0 {
-		opts.Timeout = DefaultClientOpts.Timeout
+		if opts.HTTPClient != nil {
+			// Can be 0, which means no timeout
+			opts.Timeout = opts.HTTPClient.Timeout
+		} else {
+			opts.Timeout = DefaultClientOpts.Timeout
+		}
 	}
 	if opts.TTL == 0 {
 		opts.TTL = DefaultClientOpts.TTL
 	}
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = DefaultClientOpts.NegativeTTL
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultClientOpts.MaxRetries
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = DefaultClientOpts.RetryBackoff
+	}
+	if opts.BreakerThreshold == 0 {
+		opts.BreakerThreshold = DefaultClientOpts.BreakerThreshold
+	}
+	if opts.BreakerCooldown == 0 {
+		opts.BreakerCooldown = DefaultClientOpts.BreakerCooldown
+	}
+	if opts.CatalogTTL == 0 {
+		opts.CatalogTTL = DefaultClientOpts.CatalogTTL
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: opts.Transport,
+		}
+	}
 
 	return &Client{
-		baseURL: opts.BaseURL,
-		httpClient: &http.Client{
-			Timeout: opts.Timeout,
-		},
-		cache:  cache,
-		logger: logger,
-		ttl:    opts.TTL,
+		baseURL:              opts.BaseURL,
+		httpClient:           httpClient,
+		timeout:              opts.Timeout,
+		cache:                cache,
+		logger:               logger,
+		ttl:                  opts.TTL,
+		negativeTTL:          opts.NegativeTTL,
+		staleWhileRevalidate: opts.StaleWhileRevalidate,
+		staleIfError:         opts.StaleIfError,
+		maxRetries:           opts.MaxRetries,
+		retryBackoff:         opts.RetryBackoff,
+		breaker:              newCircuitBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		requestDecorators:    opts.RequestDecorators,
+		catalogCache:         opts.CatalogCache,
+		catalogTTL:           opts.CatalogTTL,
+
+		refreshing:     map[string]struct{}{},
+		refreshingLock: &sync.Mutex{},
+	}
+}
+
+// CacheStats returns the statistics of the client's cache.
+// It returns ErrStatsNotSupported if the cache doesn't implement CacheStatsProvider.
+func (c *Client) CacheStats(ctx context.Context) (CacheStats, error) {
+	if statsProvider, ok := c.cache.(CacheStatsProvider); ok {
+		return statsProvider.Stats(ctx)
 	}
+	return CacheStats{}, ErrStatsNotSupported
 }
 
 // GetMovie returns the meta object either from the cache or from Cinemeta.
 // It automatically fills the cache with new Cinemeta responses.
 // The context can control the lifetime of the request, and if for example the timeout is shorter
-// than the HTTP client's configured timeout then it takes precedence.
-// If no timeout is set in the context, the HTTP client's timeout takes effect.
+// than the client's configured timeout then it takes precedence.
+// If no timeout is set in the context, the client's timeout takes effect.
 func (c *Client) GetMovie(ctx context.Context, imdbID string) (Meta, error) {
 	return c.getMeta(ctx, movie, imdbID, 0, 0)
 }
@@ -81,8 +223,9 @@ func (c *Client) GetMovie(ctx context.Context, imdbID string) (Meta, error) {
 // GetTVShow returns the meta object either from the cache or from Cinemeta.
 // It automatically fills the cache with new Cinemeta responses.
 // The context can control the lifetime of the request, and if for example the timeout is shorter
-// than the HTTP client's configured timeout then it takes precedence.
-// If no timeout is set in the context, the HTTP client's timeout takes effect.
+// than the client's configured timeout then it takes precedence.
+// If no timeout is set in the context, the client's timeout takes effect.
+// The returned meta's Episode field contains the requested episode, if Cinemeta knows it.
 func (c *Client) GetTVShow(ctx context.Context, imdbID string, season int, episode int) (Meta, error) {
 	return c.getMeta(ctx, tvShow, imdbID, season, episode)
 }
@@ -90,8 +233,9 @@ func (c *Client) GetTVShow(ctx context.Context, imdbID string, season int, episo
 // GetMeta returns the meta object either from the cache or from Cinemeta.
 // It automatically fills the cache with new Cinemeta responses.
 // The context can control the lifetime of the request, and if for example the timeout is shorter
-// than the HTTP client's configured timeout then it takes precedence.
-// If no timeout is set in the context, the HTTP client's timeout takes effect.
+// than the client's configured timeout then it takes precedence.
+// If no timeout is set in the context, the client's timeout takes effect.
+// For TV shows the returned meta contains the requested episode, if Cinemeta knows it.
 func (c *Client) getMeta(ctx context.Context, t mediaType, imdbID string, season int, episode int) (Meta, error) {
 	var zapFieldIMDbID zapcore.Field
 	switch t {
@@ -101,57 +245,240 @@ func (c *Client) getMeta(ctx context.Context, t mediaType, imdbID string, season
 		zapFieldIMDbID = zap.String("imdbID", fmt.Sprintf("%v:%v:%v", imdbID, season, episode))
 	}
 
+	meta, err := c.getShowOrMovieMeta(ctx, t, imdbID, zapFieldIMDbID)
+	if err != nil {
+		return Meta{}, err
+	}
+
+	if t == tvShow {
+		if video, found := meta.findEpisode(season, episode); found {
+			meta.Episode = &video
+		} else {
+			c.logger.Debug("Episode not found in TV show meta", zapFieldIMDbID)
+		}
+	}
+
+	return meta, nil
+}
+
+// getShowOrMovieMeta returns the meta object of a movie or the TV show itself either from the cache or from Cinemeta.
+// Cached "not found" results lead to an ErrNotFound, and expired items are returned if allowed by the stale options.
+func (c *Client) getShowOrMovieMeta(ctx context.Context, t mediaType, imdbID string, zapFieldIMDbID zapcore.Field) (Meta, error) {
+	// The type is part of the key, so that movie and TV show lookups for the same ID can't collide.
+	cacheKey := t.cinemetaType() + ":" + imdbID
+
 	// Check cache first
-	meta, created, found, err := c.cache.Get(imdbID)
+	var stale Meta
+	var staleIfError bool
+	meta, created, found, err := c.cache.Get(ctx, cacheKey)
+	age := time.Since(created)
 	if err != nil {
 		c.logger.Error("Couldn't decode meta", zap.Error(err), zapFieldIMDbID)
 	} else if !found {
 		c.logger.Debug("Meta not found in cache", zapFieldIMDbID)
-	} else if time.Since(created) > c.ttl {
-		expiredSince := time.Since(created.Add(c.ttl))
-		c.logger.Debug("Hit cache for meta, but item is expired", zap.Duration("expiredSince", expiredSince), zapFieldIMDbID)
-	} else {
+	} else if meta.isNegative() {
+		// Negative cache items are never stale, because Cinemeta not knowing an item isn't worth preserving
+		if age <= c.negativeTTL {
+			c.logger.Debug("Hit negative cache for meta, returning not found", zapFieldIMDbID)
+			return Meta{}, fmt.Errorf("Couldn't find %v in Cinemeta (cached): %w", t, ErrNotFound)
+		}
+		c.logger.Debug("Hit negative cache for meta, but item is expired", zapFieldIMDbID)
+	} else if age <= c.ttl {
 		c.logger.Debug("Hit cache for meta, returning result")
 		return meta, nil
+	} else {
+		expiredSince := age - c.ttl
+		if expiredSince <= c.staleWhileRevalidate {
+			c.logger.Debug("Hit cache for meta, but item is expired; returning it and refreshing it in the background", zap.Duration("expiredSince", expiredSince), zapFieldIMDbID)
+			c.refreshInBackground(t, imdbID, cacheKey, zapFieldIMDbID)
+			return meta, nil
+		}
+		c.logger.Debug("Hit cache for meta, but item is expired", zap.Duration("expiredSince", expiredSince), zapFieldIMDbID)
+		stale, staleIfError = meta, expiredSince <= c.staleIfError
 	}
 
-	var reqUrl string
-	switch t {
-	case movie:
-		reqUrl = c.baseURL + "/meta/movie/" + imdbID + ".json"
-	case tvShow:
-		reqUrl = c.baseURL + "/meta/series/" + imdbID + ".json"
+	// Then check web service
+	meta, err = c.fetchMeta(ctx, t, imdbID)
+	if errors.Is(err, ErrNotFound) {
+		c.setNegative(ctx, cacheKey, zapFieldIMDbID)
+		return Meta{}, err
+	} else if err != nil {
+		if staleIfError {
+			c.logger.Warn("Couldn't get meta from Cinemeta, returning expired item from cache", zap.Error(err), zapFieldIMDbID)
+			return stale, nil
+		}
+		return Meta{}, err
 	}
 
-	// Then check web service
-	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
-	if err != nil {
-		return Meta{}, fmt.Errorf("Couldn't create request: %v", err)
+	// Fill cache
+	c.set(ctx, cacheKey, meta, zapFieldIMDbID)
+
+	return meta, nil
+}
+
+// refreshInBackground fetches the meta from Cinemeta and fills the cache with it, without blocking the caller.
+// If the same item is already being refreshed, it does nothing.
+func (c *Client) refreshInBackground(t mediaType, imdbID, cacheKey string, zapFieldIMDbID zapcore.Field) {
+	c.refreshingLock.Lock()
+	if _, ok := c.refreshing[cacheKey]; ok {
+		c.refreshingLock.Unlock()
+		return
 	}
-	res, err := c.httpClient.Do(req)
-	if err != nil {
-		return Meta{}, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
+	c.refreshing[cacheKey] = struct{}{}
+	c.refreshingLock.Unlock()
+
+	go func() {
+		defer func() {
+			c.refreshingLock.Lock()
+			delete(c.refreshing, cacheKey)
+			c.refreshingLock.Unlock()
+		}()
+
+		// The request's context is probably done soon, so we can't use it
+		ctx := context.Background()
+		meta, err := c.fetchMeta(ctx, t, imdbID)
+		if errors.Is(err, ErrNotFound) {
+			c.setNegative(ctx, cacheKey, zapFieldIMDbID)
+			return
+		} else if err != nil {
+			c.logger.Warn("Couldn't refresh meta in the background", zap.Error(err), zapFieldIMDbID)
+			return
+		}
+		c.set(ctx, cacheKey, meta, zapFieldIMDbID)
+	}()
+}
+
+// set fills the cache with the meta.
+// The cache may keep it longer than the TTL, as long as it can be returned as stale item.
+func (c *Client) set(ctx context.Context, cacheKey string, meta Meta, zapFieldIMDbID zapcore.Field) {
+	ttl := c.ttl + c.staleWhileRevalidate
+	if c.staleIfError > c.staleWhileRevalidate {
+		ttl = c.ttl + c.staleIfError
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return Meta{}, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	if err := c.cache.Set(ctx, cacheKey, meta, ttl); err != nil {
+		c.logger.Error("Couldn't cache meta", zap.Error(err), zap.String("meta", fmt.Sprintf("%+v", meta)), zapFieldIMDbID)
 	}
-	resBody, err := ioutil.ReadAll(res.Body)
+}
+
+// setNegative fills the cache with an empty meta, which signals that Cinemeta doesn't know the item.
+func (c *Client) setNegative(ctx context.Context, cacheKey string, zapFieldIMDbID zapcore.Field) {
+	if c.negativeTTL < 0 {
+		return
+	}
+	if err := c.cache.Set(ctx, cacheKey, Meta{}, c.negativeTTL); err != nil {
+		c.logger.Error("Couldn't cache meta as not found", zap.Error(err), zapFieldIMDbID)
+	}
+}
+
+// fetchMeta gets the meta object of a movie or the TV show itself from Cinemeta.
+// It returns an error wrapping ErrNotFound if Cinemeta responds with 404 or without a name.
+func (c *Client) fetchMeta(ctx context.Context, t mediaType, imdbID string) (Meta, error) {
+	resBody, err := c.get(ctx, "/meta/"+t.cinemetaType()+"/"+imdbID+".json")
 	if err != nil {
-		return Meta{}, fmt.Errorf("Couldn't read response body: %v", err)
+		return Meta{}, err
 	}
 	cineRes := cinemetaResponse{}
 	if err := json.Unmarshal(resBody, &cineRes); err != nil {
 		return Meta{}, fmt.Errorf("Couldn't unmarshal response body: %v", err)
 	}
 	if cineRes.Meta.Name == "" {
-		return Meta{}, fmt.Errorf("Couldn't find %v name in Cinemeta response", t)
+		return Meta{}, fmt.Errorf("Couldn't find %v name in Cinemeta response: %w", t, ErrNotFound)
 	}
 
-	// Fill cache
-	if err = c.cache.Set(imdbID, cineRes.Meta); err != nil {
-		c.logger.Error("Couldn't cache meta", zap.Error(err), zap.String("meta", fmt.Sprintf("%+v", cineRes.Meta)), zapFieldIMDbID)
+	return cineRes.Meta, nil
+}
+
+// maxRetryBackoff is the cap of the exponential backoff between retries.
+const maxRetryBackoff = 30 * time.Second
+
+// retryBackoff returns the exponential backoff for the attempt, capped at maxRetryBackoff.
+// The backoff is doubled step by step instead of shifted, so that many attempts can't overflow it.
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := base
+	for i := 0; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
 	}
+	return backoff
+}
 
-	return cineRes.Meta, nil
+// get sends a GET request for the path to Cinemeta and returns the response body.
+// It retries failed requests with a jittered exponential backoff and respects the circuit breaker.
+// The client's timeout applies to all attempts together, so retries don't multiply the time that callers are blocked.
+// It returns an error wrapping ErrNotFound if Cinemeta responds with 404.
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	reqUrl := c.baseURL + path
+	if !c.breaker.allow() {
+		return nil, fmt.Errorf("Couldn't GET %v: %w", reqUrl, ErrCircuitOpen)
+	}
+	callerCtx := ctx
+	if c.timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var resBody []byte
+	var retryable bool
+	var err error
+	for attempt := 0; ; attempt++ {
+		resBody, retryable, err = c.doGet(ctx, reqUrl)
+		if err == nil || !retryable || attempt >= c.maxRetries || ctx.Err() != nil {
+			break
+		}
+		backoff := retryBackoff(c.retryBackoff, attempt)
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		c.logger.Debug("Request to Cinemeta failed, retrying", zap.Error(err), zap.Int("attempt", attempt+1), zap.Duration("backoff", backoff))
+		metrics.GetOrCreateCounter("cinemeta_retries_total").Inc()
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// Only failures that Cinemeta is responsible for count towards the circuit breaker,
+	// not for example a request that was canceled by the caller. Exceeding our own timeout is Cinemeta's failure though.
+	if err == nil || !retryable {
+		c.breaker.success()
+	} else if callerCtx.Err() == nil {
+		c.breaker.failure()
+	} else {
+		c.breaker.abort()
+	}
+	return resBody, err
+}
+
+// doGet sends a single GET request and returns the response body.
+// The boolean return value signals if a failed request can be retried.
+func (c *Client) doGet(ctx context.Context, reqUrl string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("Couldn't create request: %v", err)
+	}
+	for _, decorate := range c.requestDecorators {
+		decorate(req)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, false, fmt.Errorf("Bad GET response: %v: %w", res.StatusCode, ErrNotFound)
+	} else if res.StatusCode != http.StatusOK {
+		retryable := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("Couldn't read response body: %v", err)
+	}
+	return resBody, false, nil
 }
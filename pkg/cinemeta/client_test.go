@@ -0,0 +1,276 @@
+package cinemeta
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClientNegativeAndStaleCaching(t *testing.T) {
+	var requests, failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case atomic.LoadInt32(&failing) == 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/meta/movie/tt1.json":
+			_, _ = w.Write([]byte(`{"meta":{"name":"Foo"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cache := NewInMemoryCache()
+	client := NewClient(ClientOptions{
+		BaseURL:      server.URL,
+		TTL:          time.Hour,
+		StaleIfError: time.Hour,
+		RetryBackoff: time.Millisecond,
+	}, cache, zap.NewNop())
+	ctx := context.Background()
+
+	// Not found results must be cached
+	_, err := client.GetMovie(ctx, "tt2")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = client.GetMovie(ctx, "tt2")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.EqualValues(t, 1, atomic.LoadInt32(&requests))
+
+	meta, err := client.GetMovie(ctx, "tt1")
+	require.NoError(t, err)
+	require.Equal(t, "Foo", meta.Name)
+
+	// Expired items must be returned when Cinemeta fails
+	cache.cache["movie:tt1"] = CacheItem{Meta: meta, Created: time.Now().Add(-90 * time.Minute)}
+	atomic.StoreInt32(&failing, 1)
+	meta, err = client.GetMovie(ctx, "tt1")
+	require.NoError(t, err)
+	require.Equal(t, "Foo", meta.Name)
+
+	// But not when they're older than TTL plus StaleIfError
+	cache.cache["movie:tt1"] = CacheItem{Meta: meta, Created: time.Now().Add(-3 * time.Hour)}
+	_, err = client.GetMovie(ctx, "tt1")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+}
+
+func TestClientTypesAndEpisodes(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/meta/movie/tt1.json":
+			_, _ = w.Write([]byte(`{"meta":{"id":"tt1","type":"movie","name":"Foo movie"}}`))
+		case "/meta/series/tt1.json":
+			_, _ = w.Write([]byte(`{"meta":{"id":"tt1","type":"series","name":"Foo show","videos":[` +
+				`{"id":"tt1:1:1","name":"Pilot","season":1,"episode":1},` +
+				`{"id":"tt1:1:2","name":"Second","season":1,"number":2}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cache := NewInMemoryCache()
+	client := NewClient(ClientOptions{
+		BaseURL: server.URL,
+		TTL:     time.Hour,
+	}, cache, zap.NewNop())
+	ctx := context.Background()
+
+	// Movie and TV show with the same ID must be cached separately
+	meta, err := client.GetMovie(ctx, "tt1")
+	require.NoError(t, err)
+	require.Equal(t, "Foo movie", meta.Name)
+	require.Nil(t, meta.Episode)
+	meta, err = client.GetTVShow(ctx, "tt1", 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, "Foo show", meta.Name)
+	require.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	require.Equal(t, "Foo movie", cache.cache["movie:tt1"].Meta.Name)
+	require.Equal(t, "Foo show", cache.cache["series:tt1"].Meta.Name)
+
+	tests := []struct {
+		name    string
+		season  int
+		episode int
+		want    string
+	}{
+		{"episode", 1, 1, "Pilot"},
+		{"number", 1, 2, "Second"},
+		{"missing episode", 1, 3, ""},
+		{"missing season", 2, 1, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta, err := client.GetTVShow(ctx, "tt1", test.season, test.episode)
+			require.NoError(t, err)
+			require.Equal(t, "Foo show", meta.Name)
+			if test.want == "" {
+				require.Nil(t, meta.Episode)
+				return
+			}
+			require.NotNil(t, meta.Episode)
+			require.Equal(t, test.want, meta.Episode.Name)
+		})
+	}
+	// All episodes must be resolved from the cached TV show
+	require.EqualValues(t, 2, atomic.LoadInt32(&requests))
+	movieMeta, err := client.GetMovie(ctx, "tt1")
+	require.NoError(t, err)
+	require.Equal(t, "Foo movie", movieMeta.Name)
+	require.EqualValues(t, 2, atomic.LoadInt32(&requests))
+}
+
+func TestClientRetriesAndCircuitBreaker(t *testing.T) {
+	var requests, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the given number of requests, then succeed
+		if atomic.AddInt32(&requests, 1) <= atomic.LoadInt32(&failures) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"meta":{"name":"Foo"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{
+		BaseURL:          server.URL,
+		MaxRetries:       2,
+		RetryBackoff:     time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	}, NewInMemoryCache(), zap.NewNop())
+	ctx := context.Background()
+
+	// Two failures are covered by the retries
+	atomic.StoreInt32(&failures, 2)
+	meta, err := client.GetMovie(ctx, "tt1")
+	require.NoError(t, err)
+	require.Equal(t, "Foo", meta.Name)
+	require.EqualValues(t, 3, atomic.LoadInt32(&requests))
+
+	// Two requests with exhausted retries open the breaker
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 100)
+	for _, id := range []string{"tt2", "tt3"} {
+		_, err = client.GetMovie(ctx, id)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	require.EqualValues(t, 6, atomic.LoadInt32(&requests))
+	_, err = client.GetMovie(ctx, "tt4")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.EqualValues(t, 6, atomic.LoadInt32(&requests))
+
+	// After the cooldown a successful probe closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&failures, 0)
+	_, err = client.GetMovie(ctx, "tt4")
+	require.NoError(t, err)
+	_, err = client.GetMovie(ctx, "tt5")
+	require.NoError(t, err)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{
+		BaseURL:      server.URL,
+		Timeout:      250 * time.Millisecond,
+		MaxRetries:   5,
+		RetryBackoff: time.Millisecond,
+	}, NewInMemoryCache(), zap.NewNop())
+
+	// The timeout applies to all attempts together, not to each of them
+	start := time.Now()
+	_, err := client.GetMovie(context.Background(), "tt1")
+	require.Error(t, err)
+	require.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	require.Equal(t, 100*time.Millisecond, retryBackoff(100*time.Millisecond, 0))
+	require.Equal(t, 400*time.Millisecond, retryBackoff(100*time.Millisecond, 2))
+	// Many attempts must neither overflow nor exceed the cap
+	require.Equal(t, maxRetryBackoff, retryBackoff(100*time.Millisecond, 100))
+}
+
+func TestClientTransportAndRequestDecorators(t *testing.T) {
+	var userAgent string
+	client := NewClient(ClientOptions{
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			userAgent = req.UserAgent()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(strings.NewReader(`{"meta":{"name":"Foo"}}`)),
+			}, nil
+		}),
+		RequestDecorators: []func(*http.Request){
+			func(req *http.Request) {
+				req.Header.Set("User-Agent", "my-addon")
+			},
+		},
+	}, NewInMemoryCache(), zap.NewNop())
+
+	meta, err := client.GetMovie(context.Background(), "tt1")
+	require.NoError(t, err)
+	require.Equal(t, "Foo", meta.Name)
+	require.Equal(t, "my-addon", userAgent)
+}
+
+func TestClientCatalogAndSearch(t *testing.T) {
+	var requests int32
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		path = r.URL.EscapedPath()
+		_, _ = w.Write([]byte(`{"metas":[{"id":"tt0133093","type":"movie","name":"The Matrix","releaseInfo":"1999"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{
+		BaseURL:      server.URL,
+		CatalogCache: NewLRUCatalogCache(10, 0),
+	}, NewInMemoryCache(), zap.NewNop())
+	ctx := context.Background()
+
+	metas, err := client.Search(ctx, "movie", "the matrix")
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+	require.Equal(t, "tt0133093", metas[0].ID)
+	require.Equal(t, "/catalog/movie/top/search=the%20matrix.json", path)
+
+	_, err = client.Catalog(ctx, "series", "top", map[string]string{"skip": "100", "genre": "Sci-Fi"})
+	require.NoError(t, err)
+	require.Equal(t, "/catalog/series/top/genre=Sci-Fi&skip=100.json", path)
+
+	// The second search must be served from the cache, unaffected by changes to the first result
+	metas[0].Name = "Changed"
+	metas, err = client.Search(ctx, "movie", "the matrix")
+	require.NoError(t, err)
+	require.Equal(t, "The Matrix", metas[0].Name)
+	require.EqualValues(t, 2, atomic.LoadInt32(&requests))
+
+	_, err = client.Catalog(ctx, "channel", "top", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,382 @@
+package cinemeta
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var _ Cache = (*FileCache)(nil)
+
+// ErrCacheClosed is returned by the FileCache's methods that write to the file after the cache was closed.
+var ErrCacheClosed = errors.New("the cache is closed")
+
+// FileCacheOptions are the options for the FileCache.
+type FileCacheOptions struct {
+	// Interval for compacting the cache file.
+	// Default 1 hour.
+	CompactionInterval time.Duration
+	// Max age of items in the cache file. Older items are dropped during compaction.
+	// Should be at least as long as the TTL of the client.
+	// Default 30 days.
+	MaxAge time.Duration
+}
+
+// DefaultFileCacheOpts is an options object with sensible defaults.
+var DefaultFileCacheOpts = FileCacheOptions{
+	CompactionInterval: time.Hour,
+	MaxAge:             30 * 24 * time.Hour, // 30 days
+}
+
+// FileCache is an implementation of the Cache interface that persists its data in a file,
+// so the cache survives restarts of the addon without requiring an external service.
+// It keeps all items in memory and appends each new item to the file as a JSON line.
+// The file is compacted on startup and periodically, which removes overwritten and too old items.
+// Compaction writes to a temporary file and then renames it, so a crash can never leave a corrupted file behind.
+// A crash during an append can only lead to an incomplete last line, which is skipped when loading the file.
+type FileCache struct {
+	path   string
+	maxAge time.Duration
+	file   *os.File
+	cache  map[string]CacheItem
+	lock   *sync.Mutex
+	closed bool
+
+	// Compactions hold the compactLock, but the lock only while taking the snapshot and replacing the file
+	compactLock *sync.Mutex
+	compacting  bool
+	// Lines that were appended during the compaction, which must be appended to the new file as well
+	pendingLines [][]byte
+
+	stop      chan struct{}
+	wg        *sync.WaitGroup
+	closeOnce *sync.Once
+	closeErr  error
+}
+
+type fileCacheEntry struct {
+	Key     string    `json:"key"`
+	Meta    Meta      `json:"meta"`
+	Created time.Time `json:"created"`
+	// Deletions are appended as well, until the next compaction removes both
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// NewFileCache creates a new FileCache.
+// It loads the items from the file if it exists, then compacts the file and starts the periodic compaction.
+// Call Close when you don't need the cache anymore.
+func NewFileCache(path string, opts FileCacheOptions) (*FileCache, error) {
+	// Set defaults if necessary.
+	if opts.CompactionInterval == 0 {
+		opts.CompactionInterval = DefaultFileCacheOpts.CompactionInterval
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultFileCacheOpts.MaxAge
+	}
+
+	c := &FileCache{
+		path:        path,
+		maxAge:      opts.MaxAge,
+		cache:       map[string]CacheItem{},
+		lock:        &sync.Mutex{},
+		compactLock: &sync.Mutex{},
+		stop:        make(chan struct{}),
+		wg:          &sync.WaitGroup{},
+		closeOnce:   &sync.Once{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if err := c.Compact(); err != nil {
+		return nil, err
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(opts.CompactionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// There's no logger, and a failed compaction only means that the file grows until the next one
+				_ = c.Compact()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// load reads all items from the file into memory.
+// Later lines overwrite earlier ones with the same key, and lines that can't be decoded are skipped.
+func (c *FileCache) load() error {
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Couldn't open cache file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Meta of TV shows with many episodes can be large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry fileCacheEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Deleted {
+			delete(c.cache, entry.Key)
+			continue
+		}
+		c.cache[entry.Key] = CacheItem{
+			Meta:    entry.Meta,
+			Created: entry.Created,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Couldn't read cache file: %w", err)
+	}
+	return nil
+}
+
+// Compact rewrites the file with only the current items that aren't too old.
+// It's called periodically, so you usually don't have to call it yourself.
+// Get, Set and Delete aren't blocked while the new file is written.
+// It returns ErrCacheClosed after the cache was closed.
+func (c *FileCache) Compact() error {
+	// Only one compaction at a time
+	c.compactLock.Lock()
+	defer c.compactLock.Unlock()
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return ErrCacheClosed
+	}
+	entries := c.currentEntries()
+	// Changes during the compaction are appended to the old file as usual, and collected for the new file
+	c.compacting = true
+	c.lock.Unlock()
+
+	tmpFile, err := c.writeTempFile(entries)
+
+	c.lock.Lock()
+	pendingLines := c.pendingLines
+	c.compacting, c.pendingLines = false, nil
+	if err != nil {
+		c.lock.Unlock()
+		return err
+	}
+	if err := c.replaceFile(tmpFile, pendingLines); err != nil {
+		c.lock.Unlock()
+		return err
+	}
+	c.lock.Unlock()
+
+	// Without syncing the directory, the rename might not survive a crash
+	if err := syncDir(filepath.Dir(c.path)); err != nil {
+		return fmt.Errorf("Couldn't sync cache directory: %w", err)
+	}
+	return nil
+}
+
+// currentEntries returns the entries of all items that aren't too old and removes the others from memory.
+// The lock must be held by the caller.
+func (c *FileCache) currentEntries() []fileCacheEntry {
+	entries := make([]fileCacheEntry, 0, len(c.cache))
+	for key, item := range c.cache {
+		if time.Since(item.Created) > c.maxAge {
+			delete(c.cache, key)
+			continue
+		}
+		entries = append(entries, fileCacheEntry{
+			Key:     key,
+			Meta:    item.Meta,
+			Created: item.Created,
+		})
+	}
+	return entries
+}
+
+// writeTempFile writes the entries to a new temporary file next to the cache file and syncs it.
+// The file is returned open, so that changes during the compaction can be appended.
+func (c *FileCache) writeTempFile(entries []fileCacheEntry) (*os.File, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create temporary cache file: %w", err)
+	}
+	if err = writeEntries(tmpFile, entries); err == nil {
+		err = tmpFile.Sync()
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return nil, fmt.Errorf("Couldn't write temporary cache file: %w", err)
+	}
+	return tmpFile, nil
+}
+
+// replaceFile appends the lines to the temporary file, renames it to the cache file and opens that for appending.
+// The lines aren't synced, just like appended lines in general. The lock must be held by the caller.
+func (c *FileCache) replaceFile(tmpFile *os.File, lines [][]byte) error {
+	tmpPath := tmpFile.Name()
+	var err error
+	for _, line := range lines {
+		if _, err = tmpFile.Write(append(line, '\n')); err != nil {
+			break
+		}
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Couldn't write temporary cache file: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Couldn't rename temporary cache file: %w", err)
+	}
+
+	// The old file handle points to the replaced file, so we need to open the new one for appending
+	if c.file != nil {
+		c.file.Close()
+	}
+	if c.file, err = os.OpenFile(c.path, os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+		return fmt.Errorf("Couldn't open cache file for appending: %w", err)
+	}
+	return nil
+}
+
+// writeEntries writes the entries as lines to the file.
+func writeEntries(f *os.File, entries []fileCacheEntry) error {
+	w := bufio.NewWriter(f)
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// syncDir syncs the directory, so that renames of files in it are persisted.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Set stores a meta object and the current time in the cache and appends it to the file.
+// It returns ErrCacheClosed after the cache was closed, without storing the meta.
+func (c *FileCache) Set(key string, meta Meta) error {
+	entry := fileCacheEntry{
+		Key:     key,
+		Meta:    meta,
+		Created: time.Now(),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal cache entry: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	c.cache[key] = CacheItem{
+		Meta:    meta,
+		Created: entry.Created,
+	}
+	return c.appendLine(line)
+}
+
+// Delete removes the item from the cache and appends the deletion to the file.
+// It returns ErrCacheClosed after the cache was closed, without removing the item.
+func (c *FileCache) Delete(key string) error {
+	line, err := json.Marshal(fileCacheEntry{
+		Key:     key,
+		Deleted: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal cache entry: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrCacheClosed
+	}
+	if _, found := c.cache[key]; !found {
+		return nil
+	}
+	delete(c.cache, key)
+	return c.appendLine(line)
+}
+
+// appendLine appends the line to the file. The lock must be held by the caller.
+func (c *FileCache) appendLine(line []byte) error {
+	if c.file == nil {
+		// Only possible if opening the file for appending failed during the last compaction
+		return errors.New("cache file isn't open")
+	}
+	// A single write, so that concurrent readers of the file never see interleaved lines
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Couldn't append to cache file: %w", err)
+	}
+	if c.compacting {
+		c.pendingLines = append(c.pendingLines, line)
+	}
+	return nil
+}
+
+// Get returns a meta object and the time it was cached from the cache.
+// The boolean return value signals if the value was found in the cache.
+func (c *FileCache) Get(key string) (Meta, time.Time, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cacheItem, found := c.cache[key]
+	return cacheItem.Meta, cacheItem.Created, found, nil
+}
+
+// Close stops the periodic compaction, compacts the file a last time and closes it.
+// Afterwards the items can still be read, but not changed. Calling Close again has no effect and returns the first call's error.
+func (c *FileCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.wg.Wait()
+		err := c.Compact()
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.file != nil {
+			if closeErr := c.file.Close(); err == nil {
+				err = closeErr
+			}
+			c.file = nil
+		}
+		c.closed = true
+		c.closeErr = err
+	})
+	return c.closeErr
+}
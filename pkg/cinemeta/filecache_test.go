@@ -0,0 +1,99 @@
+package cinemeta
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cinemeta.jsonl")
+
+	c, err := NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	require.NoError(t, c.Set("movie:tt1", Meta{Name: "A"}))
+	require.NoError(t, c.Set("movie:tt2", Meta{Name: "B"}))
+	require.NoError(t, c.Set("movie:tt1", Meta{Name: "A2"}))
+
+	// Simulate a crash during an append, leaving an incomplete last line behind.
+	// The first cache isn't closed, because it would compact the file.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"movie:tt3","meta":{"na`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// A new cache must load the items that were completely written
+	c2, err := NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	meta, _, found, err := c2.Get("movie:tt1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "A2", meta.Name)
+	_, _, found, _ = c2.Get("movie:tt2")
+	require.True(t, found)
+	_, _, found, _ = c2.Get("movie:tt3")
+	require.False(t, found)
+
+	// Appending after loading must work, because the incomplete line was removed by the compaction on startup
+	require.NoError(t, c2.Set("movie:tt3", Meta{Name: "C"}))
+	require.NoError(t, c2.Close())
+	// A closed cache can be read and closed again, but not changed
+	require.NoError(t, c2.Close())
+	require.ErrorIs(t, c2.Set("movie:tt4", Meta{Name: "D"}), ErrCacheClosed)
+	require.ErrorIs(t, c2.Delete("movie:tt3"), ErrCacheClosed)
+	_, _, found, _ = c2.Get("movie:tt4")
+	require.False(t, found)
+	_, _, found, _ = c2.Get("movie:tt3")
+	require.True(t, found)
+
+	c3, err := NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer c3.Close()
+	meta, _, found, _ = c3.Get("movie:tt3")
+	require.True(t, found)
+	require.Equal(t, "C", meta.Name)
+}
+
+func TestFileCacheChangesDuringCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cinemeta.jsonl")
+
+	c, err := NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer c.Close()
+	// Many items, so that the compaction takes a while
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, c.Set("movie:tt"+strconv.Itoa(i), Meta{Name: strconv.Itoa(i)}))
+	}
+	// Items that are set during the compaction must end up in the compacted file as well
+	compactErr := make(chan error, 1)
+	go func() {
+		compactErr <- c.Compact()
+	}()
+	keys := []string{}
+	for compacting := true; compacting; {
+		select {
+		case err := <-compactErr:
+			require.NoError(t, err)
+			compacting = false
+		default:
+			key := "series:tt" + strconv.Itoa(len(keys))
+			require.NoError(t, c.Set(key, Meta{Name: key}))
+			keys = append(keys, key)
+		}
+	}
+
+	// The first cache isn't closed before, because it would compact the file with all items from memory
+	c2, err := NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer c2.Close()
+	for _, key := range keys {
+		meta, _, found, err := c2.Get(key)
+		require.NoError(t, err)
+		require.True(t, found, key)
+		require.Equal(t, key, meta.Name)
+	}
+}
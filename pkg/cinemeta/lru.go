@@ -0,0 +1,263 @@
+package cinemeta
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+var (
+	_ CacheV2            = (*LRUCache)(nil)
+	_ CacheStatsProvider = (*LRUCache)(nil)
+)
+
+// LRUCache is a size-bounded implementation of the CacheV2 interface.
+// When it's full, setting a new item evicts the least recently used one.
+// Each item expires after the TTL it was set with. Expired items are evicted when they're accessed, or when calling RemoveExpired.
+// Hits, misses and evictions are counted in the default VictoriaMetrics set, so they're exposed via the "/metrics" endpoint of an addon.
+// It doesn't persist its data.
+type LRUCache struct {
+	list *lruList
+}
+
+// LRUCacheOptions are the options for the LRUCache.
+type LRUCacheOptions struct {
+	// Prefix of the metrics names, for example "<prefix>_hits_total".
+	// Caches with the same prefix share their metrics, so caches for other data than Cinemeta's should use their own prefix.
+	// Default "cinemeta_cache".
+	MetricsPrefix string
+	// Flag for indicating whether to skip counting hits, misses and evictions in metrics.
+	// The statistics from Stats are still counted.
+	// Default false.
+	DisableMetrics bool
+}
+
+// NewLRUCache creates a new LRUCache with the default options.
+// The capacity is the maximum number of items and must be greater than 0.
+// The max age is used as expiry for items that are set with a TTL of 0.
+// A max age of 0 means that those items don't expire and are only evicted when the cache is full.
+func NewLRUCache(capacity int, maxAge time.Duration) *LRUCache {
+	return NewLRUCacheWithOptions(capacity, maxAge, LRUCacheOptions{})
+}
+
+// NewLRUCacheWithOptions creates a new LRUCache with the given options.
+// The capacity and max age are the same as for NewLRUCache.
+func NewLRUCacheWithOptions(capacity int, maxAge time.Duration, opts LRUCacheOptions) *LRUCache {
+	metricsPrefix := opts.MetricsPrefix
+	if opts.DisableMetrics {
+		metricsPrefix = ""
+	} else if metricsPrefix == "" {
+		metricsPrefix = "cinemeta_cache"
+	}
+	return &LRUCache{
+		list: newLRUList(capacity, maxAge, metricsPrefix),
+	}
+}
+
+// Set stores a meta object and the current time in the cache.
+// The item expires after the TTL, or after the cache's max age if the TTL is 0.
+// If the cache is full, the least recently used item is evicted.
+func (c *LRUCache) Set(_ context.Context, key string, meta Meta, ttl time.Duration) error {
+	c.list.set(key, meta, ttl)
+	return nil
+}
+
+// Get returns a meta object and the time it was cached from the cache.
+// The boolean return value signals if the value was found in the cache.
+// Expired items are evicted and not returned.
+func (c *LRUCache) Get(_ context.Context, key string) (Meta, time.Time, bool, error) {
+	value, created, found := c.list.get(key)
+	if !found {
+		return Meta{}, time.Time{}, false, nil
+	}
+	return value.(Meta), created, true, nil
+}
+
+// RemoveExpired evicts all expired items.
+// Expired items are evicted when they're accessed anyway, but you can call this periodically
+// to free the memory of items that are never accessed again.
+func (c *LRUCache) RemoveExpired() {
+	c.list.removeExpired()
+}
+
+// Delete removes the item from the cache.
+func (c *LRUCache) Delete(_ context.Context, key string) error {
+	c.list.delete(key)
+	return nil
+}
+
+// Stats returns the statistics of this cache.
+func (c *LRUCache) Stats(_ context.Context) (CacheStats, error) {
+	return c.list.getStats(), nil
+}
+
+// Len returns the number of items in the cache, including expired ones that weren't evicted yet.
+func (c *LRUCache) Len() int {
+	return c.list.len()
+}
+
+// lruList is the size-bounded storage with expiring items of the LRU caches.
+// It's safe for concurrent use.
+type lruList struct {
+	capacity int
+	maxAge   time.Duration
+	items    map[string]*list.Element
+	// Front is most recently used, back is least recently used
+	order *list.List
+	lock  *sync.Mutex
+
+	// Per-cache statistics, while the metrics are shared by all LRU caches with the same metrics prefix
+	stats CacheStats
+
+	// nil if metrics are disabled
+	hits              *metrics.Counter
+	misses            *metrics.Counter
+	capacityEvictions *metrics.Counter
+	expiryEvictions   *metrics.Counter
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	created time.Time
+	// Zero if the item doesn't expire
+	expires time.Time
+}
+
+// newLRUList creates a new lruList whose metrics are named with the prefix, e.g. "<prefix>_hits_total".
+// An empty prefix disables the metrics.
+func newLRUList(capacity int, maxAge time.Duration, metricsPrefix string) *lruList {
+	if capacity < 1 {
+		capacity = 1
+	}
+	l := &lruList{
+		capacity: capacity,
+		maxAge:   maxAge,
+		items:    make(map[string]*list.Element, capacity),
+		order:    list.New(),
+		lock:     &sync.Mutex{},
+	}
+	if metricsPrefix != "" {
+		l.hits = metrics.GetOrCreateCounter(metricsPrefix + "_hits_total")
+		l.misses = metrics.GetOrCreateCounter(metricsPrefix + "_misses_total")
+		l.capacityEvictions = metrics.GetOrCreateCounter(metricsPrefix + `_evictions_total{reason="capacity"}`)
+		l.expiryEvictions = metrics.GetOrCreateCounter(metricsPrefix + `_evictions_total{reason="expired"}`)
+	}
+	return l
+}
+
+func (l *lruList) set(key string, value interface{}, ttl time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	entry := lruEntry{
+		key:     key,
+		value:   value,
+		created: now,
+	}
+	if ttl == 0 {
+		ttl = l.maxAge
+	}
+	if ttl != 0 {
+		entry.expires = now.Add(ttl)
+	}
+	if elem, found := l.items[key]; found {
+		*elem.Value.(*lruEntry) = entry
+		l.order.MoveToFront(elem)
+		return
+	}
+
+	if l.order.Len() >= l.capacity {
+		back := l.order.Back()
+		l.evict(back, l.isExpired(back.Value.(*lruEntry)))
+	}
+	l.items[key] = l.order.PushFront(&entry)
+}
+
+func (l *lruList) get(key string) (interface{}, time.Time, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	elem, found := l.items[key]
+	if !found {
+		incCounter(l.misses)
+		l.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if l.isExpired(entry) {
+		l.evict(elem, true)
+		incCounter(l.misses)
+		l.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	incCounter(l.hits)
+	l.stats.Hits++
+	l.order.MoveToFront(elem)
+	return entry.value, entry.created, true
+}
+
+func (l *lruList) removeExpired() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for elem := l.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if l.isExpired(elem.Value.(*lruEntry)) {
+			l.evict(elem, true)
+		}
+		elem = prev
+	}
+}
+
+func (l *lruList) delete(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if elem, found := l.items[key]; found {
+		l.remove(elem)
+	}
+}
+
+func (l *lruList) getStats() CacheStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	stats := l.stats
+	stats.Items = l.order.Len()
+	return stats
+}
+
+func (l *lruList) len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.order.Len()
+}
+
+func (l *lruList) isExpired(entry *lruEntry) bool {
+	return !entry.expires.IsZero() && time.Now().After(entry.expires)
+}
+
+func (l *lruList) evict(elem *list.Element, expired bool) {
+	if expired {
+		incCounter(l.expiryEvictions)
+	} else {
+		incCounter(l.capacityEvictions)
+	}
+	l.stats.Evictions++
+	l.remove(elem)
+}
+
+func (l *lruList) remove(elem *list.Element) {
+	l.order.Remove(elem)
+	delete(l.items, elem.Value.(*lruEntry).key)
+}
+
+// incCounter increments the counter, unless it's nil because metrics are disabled.
+func incCounter(counter *metrics.Counter) {
+	if counter != nil {
+		counter.Inc()
+	}
+}
@@ -0,0 +1,94 @@
+package cinemeta
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2, 0)
+
+	require.NoError(t, c.Set(ctx, "a", Meta{Name: "A"}, 0))
+	require.NoError(t, c.Set(ctx, "b", Meta{Name: "B"}, 0))
+	// Access "a", so that "b" is the least recently used item
+	meta, _, found, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "A", meta.Name)
+
+	require.NoError(t, c.Set(ctx, "c", Meta{Name: "C"}, 0))
+	require.Equal(t, 2, c.Len())
+	_, _, found, _ = c.Get(ctx, "b")
+	require.False(t, found)
+	_, _, found, _ = c.Get(ctx, "a")
+	require.True(t, found)
+	_, _, found, _ = c.Get(ctx, "c")
+	require.True(t, found)
+
+	// Updating an existing item must not evict anything
+	require.NoError(t, c.Set(ctx, "a", Meta{Name: "A2"}, 0))
+	require.Equal(t, 2, c.Len())
+	meta, _, _, _ = c.Get(ctx, "a")
+	require.Equal(t, "A2", meta.Name)
+}
+
+func TestLRUCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(10, 10*time.Millisecond)
+
+	require.NoError(t, c.Set(ctx, "a", Meta{Name: "A"}, 0))
+	require.NoError(t, c.Set(ctx, "b", Meta{Name: "B"}, 0))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, c.Set(ctx, "c", Meta{Name: "C"}, 0))
+
+	_, _, found, _ := c.Get(ctx, "a")
+	require.False(t, found)
+	require.Equal(t, 2, c.Len())
+
+	c.RemoveExpired()
+	require.Equal(t, 1, c.Len())
+	_, _, found, _ = c.Get(ctx, "c")
+	require.True(t, found)
+
+	// The TTL of an item takes precedence over the max age
+	require.NoError(t, c.Set(ctx, "d", Meta{Name: "D"}, time.Hour))
+	require.NoError(t, c.Set(ctx, "e", Meta{}, time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	_, _, found, _ = c.Get(ctx, "d")
+	require.True(t, found)
+	_, _, found, _ = c.Get(ctx, "e")
+	require.False(t, found)
+}
+
+func TestLRUCacheMetrics(t *testing.T) {
+	ctx := context.Background()
+	defaultHits := metrics.GetOrCreateCounter("cinemeta_cache_hits_total")
+	hitsBefore := defaultHits.Get()
+
+	// The counters are global, so only their change can be checked, for example with "-count=2"
+	prefixedHits := metrics.GetOrCreateCounter("test_lru_cache_hits_total")
+	prefixedHitsBefore := prefixedHits.Get()
+	c := NewLRUCacheWithOptions(2, 0, LRUCacheOptions{MetricsPrefix: "test_lru_cache"})
+	require.NoError(t, c.Set(ctx, "a", Meta{Name: "A"}, 0))
+	_, _, found, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, prefixedHitsBefore+1, prefixedHits.Get())
+
+	c = NewLRUCacheWithOptions(2, 0, LRUCacheOptions{DisableMetrics: true})
+	require.NoError(t, c.Set(ctx, "a", Meta{Name: "A"}, 0))
+	_, _, found, err = c.Get(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, found)
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), stats.Hits)
+
+	// Neither cache counts in the Cinemeta cache's metrics
+	require.Equal(t, hitsBefore, defaultHits.Get())
+}
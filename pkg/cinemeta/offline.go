@@ -0,0 +1,205 @@
+package cinemeta
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// OfflineClientOptions are the options for the OfflineClient.
+type OfflineClientOptions struct {
+	// Interval for checking the dump file for changes.
+	// When its modification time or size changed, the file is loaded again.
+	// 0 disables reloading.
+	// Default 0.
+	ReloadInterval time.Duration
+}
+
+// OfflineClient returns metadata from a local dump file instead of Cinemeta,
+// for example for environments without internet access or for tests.
+// It has the same GetMovie and GetTVShow methods as the Client, so it can be used as `MetaFetcher` in go-stremio's options.
+//
+// The file must contain one Cinemeta response per line, like `{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`,
+// which is what you get when requesting Cinemeta's "/meta/movie/tt0133093.json" for example.
+// The file can be gzip compressed, which is detected automatically.
+// TV shows must contain their episodes in the meta's "videos" for the episode resolution.
+type OfflineClient struct {
+	path   string
+	logger *zap.Logger
+
+	metas   map[string]Meta
+	modTime time.Time
+	size    int64
+	lock    *sync.RWMutex
+
+	stop      chan struct{}
+	wg        *sync.WaitGroup
+	closeOnce *sync.Once
+}
+
+// NewOfflineClient creates a new OfflineClient and loads the dump file.
+// Call Close when you don't need the client anymore, to stop reloading the file.
+func NewOfflineClient(path string, opts OfflineClientOptions, logger *zap.Logger) (*OfflineClient, error) {
+	c := &OfflineClient{
+		path:      path,
+		logger:    logger,
+		lock:      &sync.RWMutex{},
+		stop:      make(chan struct{}),
+		wg:        &sync.WaitGroup{},
+		closeOnce: &sync.Once{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	if opts.ReloadInterval > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			ticker := time.NewTicker(opts.ReloadInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					c.reloadIfChanged()
+				case <-c.stop:
+					return
+				}
+			}
+		}()
+	}
+
+	return c, nil
+}
+
+// reloadIfChanged loads the file again if its modification time or size changed.
+// If loading fails, the previously loaded data is kept.
+func (c *OfflineClient) reloadIfChanged() {
+	fileInfo, err := os.Stat(c.path)
+	if err != nil {
+		c.logger.Error("Couldn't check meta dump file for changes", zap.Error(err), zap.String("path", c.path))
+		return
+	}
+	c.lock.RLock()
+	changed := !fileInfo.ModTime().Equal(c.modTime) || fileInfo.Size() != c.size
+	c.lock.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := c.load(); err != nil {
+		c.logger.Error("Couldn't reload meta dump file", zap.Error(err), zap.String("path", c.path))
+		return
+	}
+	c.logger.Info("Reloaded meta dump file", zap.String("path", c.path))
+}
+
+// load reads the file and replaces the index with its content.
+func (c *OfflineClient) load() error {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("Couldn't open meta dump file: %w", err)
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Couldn't get meta dump file info: %w", err)
+	}
+
+	// Detect gzip by its magic number instead of relying on the file extension
+	r := bufio.NewReader(f)
+	var reader io.Reader = r
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("Couldn't create gzip reader: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	metas := map[string]Meta{}
+	scanner := bufio.NewScanner(reader)
+	// Meta of TV shows with many episodes can be large
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		cineRes := cinemetaResponse{}
+		if err := json.Unmarshal(line, &cineRes); err != nil {
+			return fmt.Errorf("Couldn't unmarshal line %v of meta dump file: %w", lineNo, err)
+		}
+		if cineRes.Meta.ID == "" {
+			return fmt.Errorf("Meta in line %v of meta dump file has no ID", lineNo)
+		}
+		metas[cineRes.Meta.ID] = cineRes.Meta
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Couldn't read meta dump file: %w", err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.metas = metas
+	c.modTime = fileInfo.ModTime()
+	c.size = fileInfo.Size()
+	return nil
+}
+
+// GetMovie returns the meta object from the dump.
+// It returns an error wrapping ErrNotFound if the dump doesn't contain the movie.
+func (c *OfflineClient) GetMovie(_ context.Context, imdbID string) (Meta, error) {
+	return c.getMeta(movie, imdbID)
+}
+
+// GetTVShow returns the meta object from the dump.
+// It returns an error wrapping ErrNotFound if the dump doesn't contain the TV show.
+// The returned meta's Episode field contains the requested episode, if the dump contains it.
+func (c *OfflineClient) GetTVShow(_ context.Context, imdbID string, season int, episode int) (Meta, error) {
+	meta, err := c.getMeta(tvShow, imdbID)
+	if err != nil {
+		return Meta{}, err
+	}
+	if video, found := meta.findEpisode(season, episode); found {
+		meta.Episode = &video
+	}
+	return meta, nil
+}
+
+func (c *OfflineClient) getMeta(t mediaType, imdbID string) (Meta, error) {
+	c.lock.RLock()
+	meta, found := c.metas[imdbID]
+	c.lock.RUnlock()
+	// Metas without type are accepted for both movies and TV shows
+	if !found || (meta.Type != "" && meta.Type != t.cinemetaType()) {
+		return Meta{}, fmt.Errorf("Couldn't find %v in meta dump: %w", t, ErrNotFound)
+	}
+	return meta, nil
+}
+
+// Len returns the number of movies and TV shows in the dump.
+func (c *OfflineClient) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.metas)
+}
+
+// Close stops reloading the file. Calling it more than once is a no-op.
+func (c *OfflineClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.wg.Wait()
+	})
+	return nil
+}
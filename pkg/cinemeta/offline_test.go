@@ -0,0 +1,58 @@
+package cinemeta
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOfflineClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metas.jsonl.gz")
+	writeDump := func(lines string) {
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		w := gzip.NewWriter(f)
+		_, err = w.Write([]byte(lines))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, f.Close())
+	}
+	writeDump(`{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}
+{"meta":{"id":"tt0944947","type":"series","name":"Game of Thrones","videos":[{"id":"tt0944947:1:1","name":"Winter Is Coming","season":1,"episode":1}]}}
+`)
+
+	c, err := NewOfflineClient(path, OfflineClientOptions{ReloadInterval: 10 * time.Millisecond}, zap.NewNop())
+	require.NoError(t, err)
+	defer c.Close()
+	ctx := context.Background()
+
+	meta, err := c.GetMovie(ctx, "tt0133093")
+	require.NoError(t, err)
+	require.Equal(t, "The Matrix", meta.Name)
+
+	meta, err = c.GetTVShow(ctx, "tt0944947", 1, 1)
+	require.NoError(t, err)
+	require.NotNil(t, meta.Episode)
+	require.Equal(t, "Winter Is Coming", meta.Episode.Name)
+
+	// The type must match
+	_, err = c.GetMovie(ctx, "tt0944947")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// Changes to the file must be picked up
+	writeDump(`{"meta":{"id":"tt0133093","type":"movie","name":"Matrix"}}` + "\n")
+	require.Eventually(t, func() bool {
+		meta, err := c.GetMovie(ctx, "tt0133093")
+		return err == nil && meta.Name == "Matrix"
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, c.Len())
+
+	// Closing more than once (here and deferred) must not panic
+	require.NoError(t, c.Close())
+}
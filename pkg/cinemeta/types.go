@@ -11,6 +11,11 @@ func (mt mediaType) String() string {
 	return [...]string{"movie", "TV show"}[mt-1]
 }
 
+// cinemetaType returns the type as it's used in Cinemeta URLs.
+func (mt mediaType) cinemetaType() string {
+	return [...]string{"movie", "series"}[mt-1]
+}
+
 type cinemetaResponse struct {
 	Meta Meta `json:"meta"`
 }
@@ -38,4 +43,43 @@ type Meta struct {
 	Country     string   `json:"country,omitempty"`
 	Awards      string   `json:"awards,omitempty"`
 	Website     string   `json:"website,omitempty"`
+	Videos      []Video  `json:"videos,omitempty"` // Only for TV shows
+
+	// The episode that was requested via `Client.GetTVShow()`.
+	// It's nil for movies and when Cinemeta doesn't know the episode (yet).
+	Episode *Video `json:"-"`
+}
+
+// isNegative returns true if the meta is an empty meta that's cached to signal that Cinemeta doesn't know the item.
+// Meta from Cinemeta always has a name.
+func (m Meta) isNegative() bool {
+	return m.Name == ""
+}
+
+// findEpisode returns the video of the given episode.
+func (m Meta) findEpisode(season, episode int) (Video, bool) {
+	for _, video := range m.Videos {
+		if video.Season != season {
+			continue
+		}
+		// Some Cinemeta videos only have the number set, but not the episode
+		if video.Episode == episode || (video.Episode == 0 && video.Number == episode) {
+			return video, true
+		}
+	}
+	return Video{}, false
+}
+
+// Video represents an episode of a TV show.
+type Video struct {
+	ID      string `json:"id"`   // E.g. "tt0944947:1:1"
+	Name    string `json:"name"` // Title of the episode
+	Season  int    `json:"season"`
+	Episode int    `json:"episode"`
+
+	// Optional
+	Number    int    `json:"number,omitempty"`   // Usually the same as the episode
+	Released  string `json:"released,omitempty"` // ISO 8601, e.g. "2011-04-17T05:00:00.000Z"
+	Overview  string `json:"overview,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
 }
@@ -8,11 +8,21 @@ import (
 
 var ErrNoMeta = errors.New("no meta in context")
 
+// metaContextKey is the type of the context key for meta.
+// It's unexported, so it can't collide with context keys of other packages.
+type metaContextKey struct{}
+
+// NewContextWithMeta returns a copy of the context that contains the Meta object.
+// Use GetMetaFromContext to get it back.
+func NewContextWithMeta(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, metaContextKey{}, meta)
+}
+
 // GetMetaFromContext returns the Meta object that's stored in the context.
 // It returns an error if no meta was found in the context or the value found isn't of type Meta.
 // The former one is ErrNoMeta which acts as sentinel error so you can check for it.
 func GetMetaFromContext(ctx context.Context) (Meta, error) {
-	metaIface := ctx.Value("meta")
+	metaIface := ctx.Value(metaContextKey{})
 	if metaIface == nil {
 		return Meta{}, ErrNoMeta
 	} else if meta, ok := metaIface.(Meta); ok {
@@ -266,3 +266,11 @@ type StreamItem struct {
 	// TODO: subtitles
 	// TODO: behaviorHints
 }
+
+// SubtitleItem represents a subtitle file for a movie or TV show episode.
+// See https://github.com/Stremio/stremio-addon-sdk/blob/f6f1f2a8b627b9d4f2c62b003b251d98adadbebe/docs/api/responses/subtitles.md
+type SubtitleItem struct {
+	ID   string `json:"id"`   // Unique ID of the subtitle, for example to detect duplicates
+	URL  string `json:"url"`  // URL
+	Lang string `json:"lang"` // ISO 639-2 code, e.g. "eng"
+}